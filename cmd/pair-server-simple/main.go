@@ -23,6 +23,7 @@ func main() {
 
 	verbose := flag.Bool("v", false, "Verbose logging")
 	listenInsecure := flag.String("i", ":"+port, "network address and port to listen on (insecure)")
+	behindProxy := flag.Bool("proxied", false, "take client addresses from X-Forwarded-For, only when behind a proxy such as heroku's router that always sets it")
 	flag.Parse()
 	logFlags := 0
 	logOut := ioutil.Discard
@@ -43,9 +44,13 @@ func main() {
 	}
 
 	s := handlers.NewServer(logger, 120*time.Second)
+	s.BehindProxy = *behindProxy
 	mux := http.NewServeMux()
 	mux.HandleFunc(newrelic.WrapHandleFunc(app, "/", handlers.Index))
 	mux.HandleFunc(newrelic.WrapHandleFunc(app, "/p/", s.BasePipeHandler))
+	mux.HandleFunc(newrelic.WrapHandleFunc(app, "/m/", s.BaseMailboxHandler))
+	mux.HandleFunc(newrelic.WrapHandleFunc(app, "/c/", s.BaseShortCodeHandler))
+	mux.HandleFunc(newrelic.WrapHandleFunc(app, "/r/", s.BaseRoomHandler))
 	mux.HandleFunc(newrelic.WrapHandleFunc(app, "/metrics", s.Metrics))
 
 	srvInsecure := graceful.WithDefaults(&http.Server{
@@ -33,6 +33,9 @@ func main() {
 	mux.HandleFunc("/", handlers.Index)
 	mux.HandleFunc("/s/", s.BaseContentHandler)
 	mux.HandleFunc("/p/", s.BasePipeHandler)
+	mux.HandleFunc("/m/", s.BaseMailboxHandler)
+	mux.HandleFunc("/c/", s.BaseShortCodeHandler)
+	mux.HandleFunc("/r/", s.BaseRoomHandler)
 	mux.HandleFunc("/metrics", s.Metrics)
 
 	certManager := autocert.Manager{
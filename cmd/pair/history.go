@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/bottlerocketlabs/pair/pkg/history"
+)
+
+// historyPath is the file sessions are recorded in, empty when -history is off
+func historyPath(flagValue string) (string, error) {
+	switch flagValue {
+	case "off":
+		return "", nil
+	case "":
+		return history.DefaultPath()
+	}
+	return flagValue, nil
+}
+
+// runHistory lists the sessions recorded in path, it is pair history [flags]
+func runHistory(path string, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	since := fs.String("since", "", "Only sessions that started after this date (2006-01-02) or this long ago (such as 168h)")
+	until := fs.String("until", "", "Only sessions that started before this date (2006-01-02) or this long ago")
+	with := fs.String("with", "", "Only sessions with a participant whose name contains this")
+	role := fs.String("role", "", "Only sessions you joined as host or guest")
+	asJSON := fs.Bool("json", false, "Print the sessions as json lines")
+	_ = fs.Parse(args)
+	if path == "" {
+		return fmt.Errorf("history is off")
+	}
+	if *role != "" && *role != history.RoleHost && *role != history.RoleGuest {
+		return fmt.Errorf("unknown role %q, expected 'host' or 'guest'", *role)
+	}
+	now := time.Now()
+	filter := history.Filter{Role: *role, With: *with}
+	var err error
+	if filter.Since, err = parseHistoryTime(*since, now); err != nil {
+		return err
+	}
+	if filter.Until, err = parseHistoryTime(*until, now); err != nil {
+		return err
+	}
+	entries, err := history.Read(path)
+	if err != nil {
+		return err
+	}
+	entries = filter.Select(entries)
+	if *asJSON {
+		enc := json.NewEncoder(out)
+		for _, e := range entries {
+			if err := enc.Encode(e); err != nil {
+				return fmt.Errorf("could not write session: %w", err)
+			}
+		}
+		return nil
+	}
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "STARTED\tDURATION\tROLE\tPARTICIPANTS\tSENT\tRECEIVED\tCONNECTION\tENDED")
+	var total time.Duration
+	for _, e := range entries {
+		total += e.Duration()
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", e.Start.Local().Format("2006-01-02 15:04"), e.Duration().Round(time.Second),
+			e.Role, strings.Join(e.Participants, ", "), e.BytesSent, e.BytesReceived, connectionType(e), e.ExitReason)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("could not write sessions: %w", err)
+	}
+	_, err = fmt.Fprintf(out, "\n%d sessions, %s in total\n", len(entries), total.Round(time.Second))
+	return err
+}
+
+// connectionType is relay if either end went through a turn server, direct otherwise
+func connectionType(e history.Entry) string {
+	switch {
+	case e.LocalCandidate == "" && e.RemoteCandidate == "":
+		return "-"
+	case e.LocalCandidate == "relay" || e.RemoteCandidate == "relay":
+		return "relay"
+	}
+	return fmt.Sprintf("direct (%s/%s)", e.LocalCandidate, e.RemoteCandidate)
+}
+
+// parseHistoryTime reads a date or a duration before now, the zero time when s is empty
+func parseHistoryTime(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not read %q as a date or duration", s)
+	}
+	return now.Add(-d), nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bottlerocketlabs/pair/pkg/history"
+)
+
+func TestParseHistoryTime(t *testing.T) {
+	now := time.Date(2021, 3, 10, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		in      string
+		want    time.Time
+		wantErr bool
+	}{
+		{in: "", want: time.Time{}},
+		{in: "2021-03-01", want: time.Date(2021, 3, 1, 0, 0, 0, 0, time.Local)},
+		{in: "168h", want: now.Add(-168 * time.Hour)},
+		{in: "90m", want: now.Add(-90 * time.Minute)},
+		{in: "2021-3-1", wantErr: true},
+		{in: "last week", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseHistoryTime(tt.in, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestConnectionType(t *testing.T) {
+	tests := []struct {
+		local, remote string
+		want          string
+	}{
+		{"", "", "-"},
+		{"relay", "host", "relay"},
+		{"srflx", "relay", "relay"},
+		{"host", "srflx", "direct (host/srflx)"},
+	}
+	for _, tt := range tests {
+		e := history.Entry{LocalCandidate: tt.local, RemoteCandidate: tt.remote}
+		if got := connectionType(e); got != tt.want {
+			t.Errorf("connectionType(%q, %q) = %q, want %q", tt.local, tt.remote, got, tt.want)
+		}
+	}
+}
+
+func TestRunHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pair-history-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.jsonl")
+	now := time.Now()
+	for _, e := range []history.Entry{
+		{Start: now.Add(-30 * 24 * time.Hour), End: now.Add(-30*24*time.Hour + time.Hour), Role: history.RoleHost,
+			Participants: []string{"me", "alice"}, LocalCandidate: "host", RemoteCandidate: "host", ExitReason: "guest left"},
+		{Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour), Role: history.RoleGuest,
+			Participants: []string{"bob", "me"}, LocalCandidate: "relay", RemoteCandidate: "srflx", ExitReason: "interrupted"},
+		{Start: now.Add(-time.Hour), End: now.Add(-30 * time.Minute), Role: history.RoleHost,
+			Participants: []string{"me", "alice"}, ExitReason: "guest left"},
+	} {
+		if err := history.Append(path, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name    string
+		path    string
+		args    []string
+		want    []string
+		wantErr string
+	}{
+		{name: "all", path: path, want: []string{"me, alice", "bob, me", "3 sessions, 2h30m0s in total"}},
+		{name: "since a duration", path: path, args: []string{"-since", "168h"}, want: []string{"bob, me", "2 sessions, 1h30m0s in total"}},
+		{name: "until a date", path: path, args: []string{"-until", now.AddDate(0, 0, -7).Format("2006-01-02")}, want: []string{"direct (host/host)", "1 sessions, 1h0m0s in total"}},
+		{name: "with", path: path, args: []string{"-with", "bob"}, want: []string{"relay", "interrupted", "1 sessions"}},
+		{name: "role", path: path, args: []string{"-role", "host"}, want: []string{"2 sessions"}},
+		{name: "unknown role", path: path, args: []string{"-role", "driver"}, wantErr: "unknown role"},
+		{name: "bad since", path: path, args: []string{"-since", "yesterday"}, wantErr: "as a date or duration"},
+		{name: "off", args: nil, wantErr: "history is off"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := runHistory(tt.path, tt.args, &out)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(out.String(), "STARTED") {
+				t.Errorf("expected a table header, got:\n%s", out.String())
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(out.String(), want) {
+					t.Errorf("expected %q in:\n%s", want, out.String())
+				}
+			}
+		})
+	}
+}
+
+func TestRunHistoryJSON(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pair-history-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.jsonl")
+	now := time.Now().UTC().Truncate(time.Second)
+	for _, name := range []string{"alice", "bob"} {
+		e := history.Entry{Start: now.Add(-time.Hour), End: now, Role: history.RoleHost, Participants: []string{"me", name}, BytesSent: 10}
+		if err := history.Append(path, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	if err := runHistory(path, []string{"-json", "-with", "bob"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	var entries []history.Entry
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var e history.Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("expected a json line, got %q: %s", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 1 || entries[0].Participants[1] != "bob" || !entries[0].Start.Equal(now.Add(-time.Hour)) || entries[0].BytesSent != 10 {
+		t.Errorf("unexpected sessions %+v", entries)
+	}
+}
@@ -6,10 +6,20 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/exec"
+	"os/user"
 	"path/filepath"
+	"strings"
+	"time"
 
+	"github.com/bottlerocketlabs/pair/pkg/audit"
+	"github.com/bottlerocketlabs/pair/pkg/config"
+	"github.com/bottlerocketlabs/pair/pkg/handlers"
+	"github.com/bottlerocketlabs/pair/pkg/sandbox"
 	"github.com/bottlerocketlabs/pair/pkg/session"
+	"github.com/bottlerocketlabs/pair/pkg/sshkeys"
 	"github.com/bottlerocketlabs/pair/pkg/tmux"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/term"
 )
 
@@ -20,19 +30,68 @@ var (
 )
 
 func main() {
+	sandbox.MaybeInit()
 	showVersion := flag.Bool("version", false, "Display the version")
 	verbose := flag.Bool("v", false, "Verbose logging")
 	stunServer := flag.String("s", "stun:stun.l.google.com:19302", "The stun server to use if hosting")
-	sdpServer := flag.String("sdp", "https://pair-server-sw.herokuapp.com", "The sdp server to use if hosting")
+	sdpServer := flag.String("sdp", session.DefaultSDPServer, "The sdp server to use, a guest joining with a short code needs the same one as the host")
 	tmuxSession := flag.String("session", "pair", "The tmux session to create if hosting")
+	shareMode := flag.String("share", "extra", "The tmux session to share if hosting: 'extra' to move into a new session, 'current' to share the one you are in, 'isolated' to use a new session on a dedicated tmux server")
+	shareWindows := flag.String("windows", "", "Comma separated windows of the current session to share (with -share current)")
+	grouped := flag.Bool("grouped", false, "Give the guest their own view of the shared session so they can look at other windows")
+	follow := flag.Bool("follow", false, "Start the guest following your window when using -grouped, they can toggle it with prefix F")
+	sizePolicy := flag.String("size", session.SizeSmallest, "How to size the shared terminal when hosting: smallest, host, guest or a fixed COLSxROWS")
+	killSession := flag.Bool("kill-session", false, "Remove the tmux session pair created for sharing when hosting ends")
+	scrollback := flag.Int("scrollback", 1000, "Lines of history to send a guest with the screen when they join, -1 to send nothing")
+	sandboxed := flag.Bool("sandbox", false, "Run the shared tmux server in linux namespaces that only expose the current directory of your home, implies -share isolated")
+	sandboxNetwork := flag.Bool("sandbox-network", false, "Allow network access from the -sandbox")
+	predict := flag.String("predict", session.PredictAdaptive, "Show your typing before the host echoes it when joining: 'adaptive' on slow connections, 'always' or 'never'")
 
-	tmuxAttachCmd := []string{"tmux", "attach-session", "-t", *tmuxSession}
+	persistent := flag.Bool("persistent", false, "Keep hosting after a guest leaves, inviting the next guest into the same session")
+	expires := flag.Duration("expires", handlers.MailboxTTL, "How long the invite can be used for when hosting, up to 24h")
+	maxJoins := flag.Int("max-joins", 1, "How many guests can join one after another with the same invite when hosting")
+	shortCode := flag.Bool("code", false, "Invite with a short code that can be read aloud, such as 7-crossover-clockwork, instead of a url")
+	auth := flag.Bool("auth", false, "Only let in guests who sign in with an ssh key from -authorized-keys when hosting")
+	authorizedKeys := flag.String("authorized-keys", "", "The authorized_keys file guests sign in with a key from, implies -auth (default ~/.ssh/authorized_keys)")
+	signInvites := flag.Bool("sign", false, "Sign invites with the first key in your ssh-agent when hosting, so guests can check they are from you")
+	signKey := flag.String("sign-key", "", "The public key file of the key in your ssh-agent to sign invites with, implies -sign")
+	authKey := flag.String("auth-key", "", "The public key file of the key in your ssh-agent to sign in with when joining a host that uses -auth, by default each key in it is tried in turn")
+	hostKey := flag.String("host-key", "", "The public key file of the teammate you are joining, refuse invites they did not sign")
+	allowedSigners := flag.String("allowed-signers", "", "The allowed_signers file of teammates whose signed invites you trust when joining (default ~/.config/pair/allowed_signers if it exists)")
+	auditLog := flag.String("audit-log", "", "Append everything guests type to this file as json lines when hosting")
+	auditRedact := flag.Bool("audit-redact", false, "Leave what guests type while echo is off, such as passwords, out of the -audit-log")
+	blockPrefix := flag.Bool("block-prefix", false, "Stop guests using the tmux prefix key when hosting")
+	blockKeys := flag.String("block-keys", "", "Comma separated key sequences guests cannot send when hosting, in tmux key names where prefix is the prefix key, such as 'prefix d,prefix &,prefix :'")
+	confirmKeys := flag.String("confirm-keys", "", "Comma separated key sequences you have to allow before they are sent when hosting, such as 'prefix x'")
+	floorControl := flag.Bool("floor", false, "Only let whoever is driving type when hosting, you start driving and pass the keyboard with prefix D")
+	mobTurn := flag.Duration("mob", 0, "Rotate who drives this often when hosting, such as 7m, implies -floor")
+	coauthor := flag.Bool("coauthor", false, "Credit guests in Co-authored-by trailers of commits made in new windows of the shared session when hosting, send your git user.name and user.email for it when joining")
+	mobOrder := flag.String("mob-order", "", "Comma separated names in the order they drive with -mob, anyone but the guest drives from your keyboard, defaults to you then the guest")
+	historyFileFlag := flag.String("history", "", "File a summary of each session is appended to, history.jsonl in pair's config directory by default, 'off' to keep none, list them with pair history")
+	room := flag.String("room", "", "Host in a named room on the sdp server, guests join it with the same url every time, set PAIR_ROOM_PASSWORD to restrict it")
+	roomNoPassword := flag.Bool("room-no-password", false, "Remove the room's password so anyone with its url can join, it is kept otherwise when PAIR_ROOM_PASSWORD is not set")
+	roomOwners := flag.String("room-owners", "", "Comma separated key ids of teammates who can also host in the room, teammates are shown theirs when they try, 'none' removes them all")
 
+	// pair host [flags] always hosts, otherwise pair [flags] [url] hosts or joins depending on the url
 	flag.Parse()
+	hosting := flag.Arg(0) == "host"
+	if hosting {
+		_ = flag.CommandLine.Parse(flag.Args()[1:])
+	}
 	if *showVersion {
 		fmt.Printf("%s %s (%s)\n", filepath.Base(os.Args[0]), version, commit)
 		os.Exit(0)
 	}
+	historyFile, err := historyPath(*historyFileFlag)
+	if err != nil {
+		log.Printf("not keeping history: %s", err)
+	}
+	if flag.Arg(0) == "history" {
+		if err := runHistory(historyFile, flag.Args()[1:], os.Stdout); err != nil {
+			log.Fatalf("%s", err)
+		}
+		os.Exit(0)
+	}
 	logFlags := 0
 	logOut := ioutil.Discard
 	if *verbose {
@@ -43,6 +102,9 @@ func main() {
 	args := flag.Args()
 	offerURL := ""
 	if len(args) > 0 {
+		if hosting {
+			log.Fatalf("unexpected arguments to host: %s", strings.Join(args, " "))
+		}
 		offerURL = args[len(args)-1]
 	}
 	stdInFD := int(os.Stdin.Fd())
@@ -57,6 +119,7 @@ func main() {
 		ErrorChan:   make(chan error, 1),
 		IsTerminal:  term.IsTerminal(stdInFD),
 		StunServers: []string{*stunServer},
+		History:     historyFile,
 	}
 	if len(offerURL) == 0 {
 		debug.Println("host session")
@@ -66,35 +129,180 @@ func main() {
 		if !tmux.IsWithin(os.Environ()) {
 			log.Fatalf("please start attach to a tmux session before continuing")
 		}
-		if err := tmux.EnsureSession(*tmuxSession); err != nil {
-			log.Fatalf("failed to create extra session %s: %s", *tmuxSession, err)
-		}
-		currentSession, err := tmux.GetCurrentSession()
+		policy, err := session.ParseSizePolicy(*sizePolicy)
 		if err != nil {
-			log.Fatalf("failed to get current tmux session: %s", err)
+			log.Fatalf("%s", err)
 		}
-		debug.Printf("current session: %s", currentSession)
-		if currentSession == *tmuxSession {
-			log.Fatalf("should not already be in this session, please create another and attach to that")
+		if *expires < time.Second || *expires > handlers.MaxInviteTTL {
+			log.Fatalf("-expires should be between 1s and %s", handlers.MaxInviteTTL)
 		}
-		clients, err := tmux.GetClientsInSession(currentSession)
+		if *maxJoins < 1 {
+			log.Fatalf("-max-joins should be at least 1")
+		}
+		var keys sshkeys.AuthorizedKeys
+		if *auth || *authorizedKeys != "" {
+			path := *authorizedKeys
+			if path == "" {
+				if path, err = sshkeys.DefaultAuthorizedKeys(); err != nil {
+					log.Fatalf("%s", err)
+				}
+			}
+			if keys, err = sshkeys.LoadAuthorizedKeys(path); err != nil {
+				log.Fatalf("%s", err)
+			}
+			debug.Printf("guests can sign in with %d keys from %s", len(keys), path)
+		}
+		var signingKey ssh.PublicKey
+		if *signKey != "" {
+			if signingKey, err = sshkeys.LoadPublicKey(*signKey); err != nil {
+				log.Fatalf("%s", err)
+			}
+		}
+		if *room != "" && !handlers.ValidRoomName(*room) {
+			log.Fatalf("room names are lowercase letters, numbers, '.', '_' and '-': %q", *room)
+		}
+		// the room keeps the password it has unless one is given or removed
+		var roomPassword *string
+		password := os.Getenv("PAIR_ROOM_PASSWORD")
+		if *roomNoPassword {
+			roomPassword = new(string)
+		} else if password != "" {
+			roomPassword = &password
+		}
+		var owners []string
+		switch *roomOwners {
+		case "":
+		case "none":
+			owners = []string{}
+		default:
+			if owners, err = handlers.ParseRoomOwners(*roomOwners); err != nil {
+				log.Fatalf("%s", err)
+			}
+		}
+		hostTmux := tmux.Server{}
+		hostState, err := hostTmux.ClientState()
 		if err != nil {
-			log.Fatalf("could not get tmux clients: %s", err)
+			log.Fatalf("failed to get current tmux client: %s", err)
 		}
+		currentSession := hostState.Session
+		debug.Printf("current session: %s", currentSession)
+		sharedSession := *tmuxSession
 		client := ""
-		for _, c := range clients {
-			if c == "" {
-				continue
+		created := false
+		var isolated *tmux.Server
+		var attachCmd []string
+		if *sandboxed {
+			*shareMode = "isolated"
+		}
+		switch *shareMode {
+		case "extra":
+			if currentSession == *tmuxSession {
+				log.Fatalf("should not already be in this session, please create another and attach to that")
+			}
+			created, err = hostTmux.EnsureSession(*tmuxSession)
+			if err != nil {
+				log.Fatalf("failed to create extra session %s: %s", *tmuxSession, err)
+			}
+			client = hostState.Client
+		case "current":
+			if *shareWindows == "" {
+				sharedSession = currentSession
+				break
+			}
+			if currentSession == *tmuxSession {
+				log.Fatalf("cannot share windows into the session you are in, please choose another with -session")
+			}
+			if err := hostTmux.LinkWindows(currentSession, *tmuxSession, strings.Split(*shareWindows, ",")); err != nil {
+				log.Fatalf("failed to share windows %s: %s", *shareWindows, err)
+			}
+			created = true
+		case "isolated":
+			srv, err := tmux.NewIsolatedServer()
+			if err != nil {
+				log.Fatalf("failed to prepare isolated tmux server: %s", err)
+			}
+			isolated = &srv
+			cwd, err := os.Getwd()
+			if err != nil {
+				_ = srv.Kill()
+				log.Fatalf("could not get working directory: %s", err)
+			}
+			if *sandboxed {
+				err = startSandboxedSession(srv, *tmuxSession, cwd, *sandboxNetwork)
+			} else {
+				err = srv.NewSession(*tmuxSession, cwd)
+			}
+			if err != nil {
+				_ = srv.Kill()
+				log.Fatalf("failed to create isolated session %s: %s", *tmuxSession, err)
+			}
+			if err := srv.OpenInWindow(hostTmux, currentSession, *tmuxSession); err != nil {
+				_ = srv.Kill()
+				log.Fatalf("failed to open isolated session %s: %s", *tmuxSession, err)
 			}
-			client = c
+			attachCmd = srv.AttachCommand(*tmuxSession)
+		default:
+			log.Fatalf("unknown share mode %q, expected 'extra', 'current' or 'isolated'", *shareMode)
 		}
+		debug.Printf("shared session: %s", sharedSession)
+		if attachCmd == nil {
+			attachCmd = []string{"tmux", "attach-session", "-t", sharedSession}
+		}
+		var auditor *audit.Log
+		if *auditLog != "" {
+			if auditor, err = audit.Open(*auditLog); err != nil {
+				log.Fatalf("%s", err)
+			}
+		}
+		identity, err := session.LoadIdentity()
+		if err != nil {
+			log.Printf("guests will not recognise you from last time: %s", err)
+		}
+		baseSession.Certificate = identity
+		var inputPolicy session.InputPolicy
+		if *blockPrefix {
+			inputPolicy.Block = append(inputPolicy.Block, "prefix")
+		}
+		inputPolicy.Block = append(inputPolicy.Block, splitList(*blockKeys)...)
+		inputPolicy.Confirm = splitList(*confirmKeys)
 		hs := session.HostSession{
-			TmuxClient:  client,
-			TmuxSession: *tmuxSession,
-			Session:     baseSession,
-			Cmd:         tmuxAttachCmd,
+			Tmux:              hostTmux,
+			TmuxClient:        client,
+			TmuxSession:       sharedSession,
+			HostState:         hostState,
+			KillSessionOnExit: created && *killSession,
+			IsolatedServer:    isolated,
+			Grouped:           *grouped,
+			Follow:            *follow,
+			SizePolicy:        policy,
+			Scrollback:        *scrollback,
+			Persistent:        *persistent,
+			InviteTTL:         *expires,
+			MaxJoins:          *maxJoins,
+			ShortCode:         *shortCode,
+			Name:              hostName(),
+			AuthorizedKeys:    keys,
+			Room:              *room,
+			RoomPassword:      roomPassword,
+			RoomOwners:        owners,
+			SignInvites:       *signInvites || signingKey != nil,
+			SigningKey:        signingKey,
+			AuditLog:          auditor,
+			AuditRedact:       *auditRedact,
+			InputPolicy:       inputPolicy,
+			FloorControl:      *floorControl,
+			Mob:               session.Mob{Turn: *mobTurn, Order: splitList(*mobOrder)},
+			Coauthors:         *coauthor,
+			Session:           baseSession,
+			Cmd:               attachCmd,
 		}
 		err = hs.Run()
+		// closed here rather than deferred, as log.Fatalf exits without running deferred calls
+		if auditor != nil {
+			if err := auditor.Close(); err != nil {
+				log.Printf("could not close audit log: %s", err)
+			}
+		}
 		if err != nil {
 			log.Fatalf("could not start host session: %s", err)
 		}
@@ -103,14 +311,145 @@ func main() {
 		if tmux.IsWithin(os.Environ()) {
 			log.Fatalf("please detach from any tmux sessions before continuing")
 		}
+		switch *predict {
+		case session.PredictAdaptive, session.PredictAlways, session.PredictNever:
+		default:
+			log.Fatalf("unknown prediction mode %q, expected 'adaptive', 'always' or 'never'", *predict)
+		}
+		signers, err := loadAllowedSigners(*allowedSigners, *hostKey)
+		if err != nil {
+			log.Fatalf("%s", err)
+		}
+		var signInKey ssh.PublicKey
+		if *authKey != "" {
+			if signInKey, err = sshkeys.LoadPublicKey(*authKey); err != nil {
+				log.Fatalf("%s", err)
+			}
+		}
 		cs := session.ClientSession{
-			Session:  baseSession,
-			OfferURL: offerURL,
+			Session:        baseSession,
+			OfferURL:       offerURL,
+			RoomPassword:   os.Getenv("PAIR_ROOM_PASSWORD"),
+			Predict:        *predict,
+			AllowedSigners: signers,
+			AuthKey:        signInKey,
+		}
+		if *coauthor {
+			cs.Name, cs.Email = gitConfig("user.name"), gitConfig("user.email")
 		}
-		err := cs.Run()
+		err = cs.Run()
 		if err != nil {
 			log.Fatalf("could not start client session: %s", err)
 		}
 	}
 	debug.Printf("kthnxbai")
 }
+
+// gitConfig is a value from git's config, empty if it is unset or git is missing
+func gitConfig(key string) string {
+	b, err := exec.Command("git", "config", key).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// splitList splits a comma separated flag, dropping empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadAllowedSigners returns the keys trusted to sign invites from the allowed_signers file at
+// path and the key in hostKey, which can sign as any host. Nil means invites are not checked.
+func loadAllowedSigners(path, hostKey string) (sshkeys.AllowedSigners, error) {
+	var signers sshkeys.AllowedSigners
+	if hostKey != "" {
+		key, err := sshkeys.LoadPublicKey(hostKey)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, sshkeys.AllowedSigner{Principals: []string{"*"}, Key: key})
+	}
+	if path == "" {
+		dir, err := config.Dir()
+		if err != nil {
+			return signers, nil
+		}
+		path = filepath.Join(dir, "allowed_signers")
+		if _, err := os.Stat(path); err != nil {
+			return signers, nil
+		}
+	}
+	allowed, err := sshkeys.LoadAllowedSigners(path)
+	if err != nil {
+		return nil, err
+	}
+	if signers == nil {
+		signers = sshkeys.AllowedSigners{}
+	}
+	return append(signers, allowed...), nil
+}
+
+// startSandboxedSession starts the tmux server for session inside a sandbox that hides the
+// home and temporary directories apart from dir and the server's own, the server outlives
+// this call until it is killed
+func startSandboxedSession(srv tmux.Server, session, dir string, network bool) error {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("could not find home directory to hide: %w", err)
+	}
+	cfg := sandbox.Config{
+		ProjectDir: dir,
+		Hide:       append([]string{home}, sandbox.TempDirs...),
+		Expose:     []string{srv.Dir},
+		Network:    network,
+	}
+	cmd, err := sandbox.Command(cfg, srv.Args("new-session", "-d", "-s", session, "-c", dir))
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start sandbox: %w", err)
+	}
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if ok, _ := srv.HasSession(session); ok {
+			// clients attaching from outside would otherwise copy variables such as
+			// SSH_AUTH_SOCK into the sandbox's new panes
+			if _, err := srv.Run("set-option", "-g", "update-environment", ""); err != nil {
+				return fmt.Errorf("could not stop clients updating the sandbox environment: %w", err)
+			}
+			return nil
+		}
+		select {
+		case err := <-exited:
+			return fmt.Errorf("sandbox exited before session started: %v", err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	return fmt.Errorf("timed out waiting for sandboxed session %s", session)
+}
+
+// hostName identifies the host to guests as user@hostname
+func hostName() string {
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return name
+	}
+	return name + "@" + hostname
+}
@@ -31,12 +31,12 @@ func TestEncodeDecodeSD(t *testing.T) {
 	}
 	s, err := offer.Encode()
 	if err != nil {
-		t.Errorf("unexpected error encoding offer: %w", err)
+		t.Errorf("unexpected error encoding offer: %v", err)
 	}
 	var decoded session.SessionDescription
 	err = decoded.Decode(s)
 	if err != nil {
-		t.Errorf("unexpected error decoding offer: %w", err)
+		t.Errorf("unexpected error decoding offer: %v", err)
 	}
 	if decoded.SDP != offer.SDP {
 		t.Errorf("should match: \n%q\n%q\n", decoded.SDP, offer.SDP)
@@ -14,6 +14,7 @@ require (
 	github.com/pion/webrtc/v2 v2.2.26
 	github.com/sirupsen/logrus v1.7.0
 	golang.org/x/crypto v0.0.0-20200709230013-948cd5f35899
+	golang.org/x/sys v0.0.0-20201119102817-f84b799fce68
 	golang.org/x/term v0.0.0-20201210144234-2321bbc49cbf
 )
 
@@ -0,0 +1,71 @@
+// Package audit records what guests type into the host's terminal as JSON lines.
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Kinds of input recorded
+const (
+	KindBinary = "binary"
+	KindStdin  = "stdin"
+)
+
+// Entry is one message of input from a guest. Guest is the name they signed in with, if
+// they did, and GuestKey the fingerprint of their connection's certificate. Input is left
+// out when it is redacted, Bytes is always its length.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Host     string    `json:"host"`
+	Guest    string    `json:"guest,omitempty"`
+	GuestKey string    `json:"guest_key,omitempty"`
+	Kind     string    `json:"kind"`
+	Input    string    `json:"input,omitempty"`
+	Bytes    int       `json:"bytes"`
+	Redacted bool      `json:"redacted,omitempty"`
+}
+
+// Log appends entries to a file, entries already written are never changed
+type Log struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// Open opens the log at path for appending, creating it readable only by the host
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log: %w", err)
+	}
+	return &Log{f: f}, nil
+}
+
+// Record appends e as a line of json, each line is written in one go so concurrent
+// writers do not interleave
+func (l *Log) Record(e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not marshal audit entry: %w", err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("could not write audit log: %w", err)
+	}
+	return nil
+}
+
+// Close syncs and closes the file, entries cannot be recorded after
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.f.Sync(); err != nil {
+		l.f.Close()
+		return fmt.Errorf("could not sync audit log: %w", err)
+	}
+	return l.f.Close()
+}
@@ -0,0 +1,57 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	now := time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []Entry{
+		{Time: now, Host: "host@box", Guest: "alice", Kind: KindStdin, Input: "ls\r", Bytes: 3},
+		{Time: now, Host: "host@box", Guest: "alice", Kind: KindBinary, Bytes: 7, Redacted: true},
+	}
+	for i, e := range entries {
+		// reopening appends rather than truncating
+		l, err := Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := l.Record(e); err != nil {
+			t.Fatalf("entry %d: %s", i, err)
+		}
+		if err := l.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	var got []Entry
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("line %q is not json: %s", scanner.Text(), err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != len(entries) {
+		t.Fatalf("expected %d entries, got %d", len(entries), len(got))
+	}
+	for i := range entries {
+		if got[i] != entries[i] {
+			t.Errorf("entry %d: expected %+v, got %+v", i, entries[i], got[i])
+		}
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("expected audit log to be private: %v %v", info.Mode(), err)
+	}
+}
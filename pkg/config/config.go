@@ -0,0 +1,21 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Dir is where pair keeps keys and other state between sessions, such as ~/.config/pair,
+// it is created if it does not exist
+func Dir(elem ...string) (string, error) {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find config directory: %w", err)
+	}
+	dir := filepath.Join(append([]string{base, "pair"}, elem...)...)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("could not create config directory: %w", err)
+	}
+	return dir, nil
+}
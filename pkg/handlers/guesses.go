@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// guesses limits wrong guesses at secrets, such as short codes, by client address. A client
+// that makes too many is locked out for a while, without affecting anyone else.
+type guesses struct {
+	mu      sync.Mutex
+	max     int
+	lockout time.Duration
+	clients map[string]*clientGuesses
+}
+
+// clientGuesses are a client's wrong guesses, forgotten lockout after the last of them
+type clientGuesses struct {
+	wrong int
+	until time.Time
+}
+
+func newGuesses(max int, lockout time.Duration) *guesses {
+	return &guesses{max: max, lockout: lockout, clients: make(map[string]*clientGuesses)}
+}
+
+func (g *guesses) purge(now time.Time) {
+	for client, c := range g.clients {
+		if now.After(c.until) {
+			delete(g.clients, client)
+		}
+	}
+}
+
+// allowed reports whether client can make another guess
+func (g *guesses) allowed(client string, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.purge(now)
+	c, ok := g.clients[client]
+	return !ok || c.wrong < g.max
+}
+
+// wrong records a wrong guess by client
+func (g *guesses) wrong(client string, now time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c, ok := g.clients[client]
+	if !ok {
+		c = &clientGuesses{}
+		g.clients[client] = c
+	}
+	c.wrong++
+	c.until = now.Add(g.lockout)
+}
+
+// clientAddr is the address a request came from, the last address in X-Forwarded-For
+// when the server runs behind a proxy that adds it
+func (s *server) clientAddr(r *http.Request) string {
+	if s.BehindProxy {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			addrs := strings.Split(fwd, ",")
+			return clientPrefix(strings.TrimSpace(addrs[len(addrs)-1]))
+		}
+	}
+	return clientPrefix(justIP(r.RemoteAddr))
+}
+
+// clientPrefix keys IPv6 clients on their /64, as a single client is usually given the
+// whole prefix and could otherwise make its guesses from a new address each time
+func clientPrefix(addr string) string {
+	ip := net.ParseIP(strings.Trim(addr, "[]"))
+	if ip == nil || ip.To4() != nil {
+		return addr
+	}
+	return ip.Mask(net.CIDRMask(64, 128)).String() + "/64"
+}
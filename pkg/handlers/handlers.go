@@ -33,6 +33,16 @@ func Index(w http.ResponseWriter, r *http.Request) {
 	/s/<path> - GET fetch file [within 2 min of creation]
 	/p/<path> - PUT stream content to reciever once listening
 	/p/<path> - GET stream content from sender once sending
+	/m/<path> - PUT leave a message [up to 10kb content for ~10 min]
+	            Pair-Expires-In: <seconds> and Pair-Max-Joins: <n> on the first PUT limit its use [up to 24 hours]
+	/m/<path> - GET collect a message, ?wait=<seconds> to wait for it to arrive, 410 once expired or used
+	/c/       - POST give a mailbox url a short code, its words in the Pair-Code-Secret header [10 per address an hour, for up to 1 hour]
+	/c/<slot> - GET resolve a short code to its mailbox [3 wrong guesses per address every 10 min, 10 per code]
+	/r/<name> - PUT host in a room, the offer's mailbox url as content, claimed with the Pair-Room-Key header
+	            Pair-Room-Owners: <key ids> lets teammates host in it with their own keys
+	            Pair-Room-Password sets its password, empty removes it, kept when left out
+	/r/<name> - GET the mailbox of the current offer in a room, Pair-Room-Password if it has one
+	/r/<name> - DELETE stop hosting in a room
 	/metrics  - GET metrics
 `))
 }
@@ -71,15 +81,26 @@ func NewServer(logger *log.Logger, fileTTL time.Duration) server {
 		log:           logger,
 		files:         ttlcache.NewCache(fileTTL),
 		pipeReceivers: make(map[string]*receiver),
+		mailboxes:     newMailboxes(),
+		shortCodes:    newShortCodes(),
+		rooms:         newRooms(),
 	}
 }
 
 type server struct {
+	// BehindProxy takes client addresses from X-Forwarded-For, only set it when a proxy
+	// in front of the server always adds it
+	BehindProxy bool
+
 	log   *log.Logger
 	files *ttlcache.Cache
 
 	rwm           sync.RWMutex
 	pipeReceivers map[string]*receiver
+
+	mailboxes  *mailboxes
+	shortCodes *shortCodes
+	rooms      *rooms
 }
 
 func (s *server) getReciever(path string) (*receiver, bool) {
@@ -228,6 +249,9 @@ func (s *server) Metrics(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write([]byte(fmt.Sprintf("file_count: %d\n", s.files.Count())))
 	w.Write([]byte(fmt.Sprintf("pipe_count: %d\n", len(s.pipeReceivers))))
+	w.Write([]byte(fmt.Sprintf("mailbox_count: %d\n", s.mailboxes.count())))
+	w.Write([]byte(fmt.Sprintf("short_code_count: %d\n", s.shortCodes.count())))
+	w.Write([]byte(fmt.Sprintf("room_count: %d\n", s.rooms.count())))
 }
 
 func justIP(hostPort string) string {
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bottlerocketlabs/pair/pkg/random"
+)
+
+// MailboxTTL is how long a message waits in a mailbox to be collected, unless the sender asks otherwise
+const MailboxTTL = 10 * time.Minute
+
+// MaxInviteTTL is the longest a sender can ask a mailbox to be kept for
+const MaxInviteTTL = 24 * time.Hour
+
+// tombstoneTTL is how long an expired mailbox is remembered, so it can be reported as expired rather than missing
+const tombstoneTTL = time.Hour
+
+// maxMailboxWait is the longest a GET waits for a message to arrive
+const maxMailboxWait = 60 * time.Second
+
+// maxMailboxSize is the largest message a mailbox holds
+const maxMailboxSize = 10240
+
+// Headers on the first PUT to a mailbox that limit how it can be used
+const (
+	ExpiresInHeader = "Pair-Expires-In"
+	MaxJoinsHeader  = "Pair-Max-Joins"
+)
+
+var (
+	ErrExpired = errors.New("invite has expired")
+	ErrUsed    = errors.New("invite has already been used")
+
+	errNotFound = errors.New("404 page not found")
+	errEmpty    = errors.New("no message waiting, try again shortly")
+	errFull     = errors.New("mailbox already has a message")
+)
+
+// GenMailboxURL returns a new mailbox on host for passing a session description
+func GenMailboxURL(host string) string {
+	u, err := url.Parse(host)
+	if err != nil {
+		panic(fmt.Sprintf("badly formed host provided: %s", host))
+	}
+	randPath, _ := random.String(32)
+	u.Path = path.Join("/m", randPath)
+	return u.String()
+}
+
+// InvitePolicy limits how long a mailbox lasts and how many messages can be collected from it,
+// so an invite cannot be replayed after it has been used
+type InvitePolicy struct {
+	ExpiresIn time.Duration
+	MaxJoins  int
+}
+
+// SetHeaders adds the policy to the request creating the mailbox
+func (p InvitePolicy) SetHeaders(h http.Header) {
+	if p.ExpiresIn > 0 {
+		secs := (p.ExpiresIn + time.Second - 1) / time.Second
+		h.Set(ExpiresInHeader, strconv.Itoa(int(secs)))
+	}
+	if p.MaxJoins > 0 {
+		h.Set(MaxJoinsHeader, strconv.Itoa(p.MaxJoins))
+	}
+}
+
+func parseInvitePolicy(h http.Header) (InvitePolicy, error) {
+	p := InvitePolicy{ExpiresIn: MailboxTTL, MaxJoins: 1}
+	if v := h.Get(ExpiresInHeader); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			return p, fmt.Errorf("%s should be a positive number of seconds: %q", ExpiresInHeader, v)
+		}
+		p.ExpiresIn = time.Duration(secs) * time.Second
+		if p.ExpiresIn > MaxInviteTTL {
+			p.ExpiresIn = MaxInviteTTL
+		}
+	}
+	if v := h.Get(MaxJoinsHeader); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return p, fmt.Errorf("%s should be a positive number: %q", MaxJoinsHeader, v)
+		}
+		p.MaxJoins = n
+	}
+	return p, nil
+}
+
+// mailbox holds a message until it is collected, it can be refilled until it has been
+// collected from maxTakes times or expires
+type mailbox struct {
+	body     []byte
+	expires  time.Time
+	maxTakes int
+	takes    int
+	arrived  chan struct{}
+	// placeholder is set while the mailbox only exists for GETs waiting on it, the first
+	// PUT sets its policy and it is forgotten if nothing arrives
+	placeholder bool
+	waiters     int
+}
+
+func newMailbox(p InvitePolicy, now time.Time) *mailbox {
+	return &mailbox{
+		expires:  now.Add(p.ExpiresIn),
+		maxTakes: p.MaxJoins,
+		arrived:  make(chan struct{}),
+	}
+}
+
+// usable reports why the mailbox can no longer be used, if it cannot
+func (b *mailbox) usable(now time.Time) error {
+	if now.After(b.expires) {
+		return ErrExpired
+	}
+	if b.takes >= b.maxTakes {
+		return ErrUsed
+	}
+	return nil
+}
+
+type mailboxes struct {
+	mu    sync.Mutex
+	boxes map[string]*mailbox
+}
+
+func newMailboxes() *mailboxes {
+	return &mailboxes{boxes: make(map[string]*mailbox)}
+}
+
+// purge forgets mailboxes that expired a while ago
+func (m *mailboxes) purge(now time.Time) {
+	for p, b := range m.boxes {
+		if now.After(b.expires.Add(tombstoneTTL)) {
+			delete(m.boxes, p)
+		}
+	}
+}
+
+// put leaves a message at path, the policy only applies when this creates the mailbox
+func (m *mailboxes) put(path string, body []byte, policy InvitePolicy) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	m.purge(now)
+	b, ok := m.boxes[path]
+	if !ok {
+		b = newMailbox(policy, now)
+		m.boxes[path] = b
+	} else if b.placeholder {
+		b.expires = now.Add(policy.ExpiresIn)
+		b.maxTakes = policy.MaxJoins
+		b.placeholder = false
+	}
+	if err := b.usable(now); err != nil {
+		return err
+	}
+	if b.body != nil {
+		return errFull
+	}
+	b.body = body
+	close(b.arrived)
+	return nil
+}
+
+// take collects the message at path, waiting up to wait for it to arrive
+func (m *mailboxes) take(path string, wait time.Duration, done <-chan struct{}) ([]byte, error) {
+	m.mu.Lock()
+	now := time.Now()
+	m.purge(now)
+	b, ok := m.boxes[path]
+	if !ok {
+		if wait == 0 {
+			m.mu.Unlock()
+			return nil, errNotFound
+		}
+		b = newMailbox(InvitePolicy{ExpiresIn: MailboxTTL, MaxJoins: 1}, now)
+		b.placeholder = true
+		m.boxes[path] = b
+	}
+	if err := b.usable(now); err != nil {
+		m.mu.Unlock()
+		return nil, err
+	}
+	arrived := b.arrived
+	b.waiters++
+	m.mu.Unlock()
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-arrived:
+		case <-timer.C:
+		case <-done:
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	b.waiters--
+	if b.placeholder && b.waiters == 0 && m.boxes[path] == b {
+		delete(m.boxes, path)
+		return nil, errEmpty
+	}
+	if m.boxes[path] != b {
+		return nil, errNotFound
+	}
+	if err := b.usable(time.Now()); err != nil {
+		return nil, err
+	}
+	if b.body == nil {
+		return nil, errEmpty
+	}
+	body := b.body
+	b.body = nil
+	b.takes++
+	b.arrived = make(chan struct{})
+	return body, nil
+}
+
+func (m *mailboxes) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.boxes)
+}
+
+// mailboxStatus is the response code for an error using a mailbox
+func mailboxStatus(err error) int {
+	switch err {
+	case ErrExpired, ErrUsed:
+		return http.StatusGone
+	case errFull:
+		return http.StatusConflict
+	}
+	return http.StatusNotFound
+}
+
+// BaseMailboxHandler stores a message until it is collected, so the sender and receiver
+// do not need to be connected at the same time. A GET with ?wait=<seconds> waits for
+// a message to arrive, responding 204 No Content if none did. The first PUT can limit
+// how long the mailbox lasts and how many times it can be collected from with headers,
+// after which it responds 410 Gone.
+func (s *server) BaseMailboxHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		var wait time.Duration
+		if v := r.URL.Query().Get("wait"); v != "" {
+			secs, err := strconv.Atoi(v)
+			if err != nil || secs < 0 {
+				http.Error(w, fmt.Sprintf("wait should be a number of seconds: %q", v), http.StatusBadRequest)
+				return
+			}
+			wait = time.Duration(secs) * time.Second
+			if wait > maxMailboxWait {
+				wait = maxMailboxWait
+			}
+		}
+		body, err := s.mailboxes.take(r.URL.Path, wait, r.Context().Done())
+		if err == errEmpty && wait > 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), mailboxStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+	case http.MethodPut:
+		policy, err := parseInvitePolicy(r.Header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxMailboxSize+1))
+		if err != nil || len(body) > maxMailboxSize {
+			http.Error(w, fmt.Sprintf("body of request is over %d bytes", maxMailboxSize), http.StatusBadRequest)
+			return
+		}
+		if len(body) == 0 {
+			http.Error(w, "body of request is 0 bytes", http.StatusBadRequest)
+			return
+		}
+		if err := s.mailboxes.put(r.URL.Path, body, policy); err != nil {
+			http.Error(w, err.Error(), mailboxStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("CREATED\n"))
+	default:
+		http.Error(w, fmt.Sprintf("unexpected method used: %s", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+}
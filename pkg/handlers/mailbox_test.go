@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	s := NewServer(log.New(ioutil.Discard, "", 0), time.Minute)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/m/", s.BaseMailboxHandler)
+	mux.HandleFunc("/c/", s.BaseShortCodeHandler)
+	mux.HandleFunc("/r/", s.BaseRoomHandler)
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func do(t *testing.T, method, url, body string) (int, string) {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestMailbox(t *testing.T) {
+	ts := newTestServer(t)
+	url := GenMailboxURL(ts.URL)
+	if !strings.HasPrefix(url, ts.URL+"/m/") {
+		t.Fatalf("unexpected mailbox url: %s", url)
+	}
+	if code, _ := do(t, http.MethodGet, url, ""); code != http.StatusNotFound {
+		t.Errorf("expected empty mailbox to be not found: %d", code)
+	}
+	if code, _ := do(t, http.MethodPut, url, "offer"); code != http.StatusCreated {
+		t.Errorf("expected message to be created: %d", code)
+	}
+	if code, _ := do(t, http.MethodPut, url, "replacement"); code != http.StatusConflict {
+		t.Errorf("expected message not to be replaced: %d", code)
+	}
+	if code, body := do(t, http.MethodGet, url, ""); code != http.StatusOK || body != "offer" {
+		t.Errorf("unexpected message: %d %q", code, body)
+	}
+	if code, body := do(t, http.MethodGet, url, ""); code != http.StatusGone || !strings.Contains(body, "already been used") {
+		t.Errorf("expected message to be collected only once: %d %q", code, body)
+	}
+	if code, _ := do(t, http.MethodPut, url, "again"); code != http.StatusGone {
+		t.Errorf("expected used mailbox not to be refilled: %d", code)
+	}
+}
+
+func TestMailboxPolicy(t *testing.T) {
+	ts := newTestServer(t)
+	url := GenMailboxURL(ts.URL)
+	put := func(body string, policy InvitePolicy) int {
+		req, err := http.NewRequest(http.MethodPut, url, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		policy.SetHeaders(req.Header)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if code := put("offer", InvitePolicy{ExpiresIn: time.Hour, MaxJoins: 2}); code != http.StatusCreated {
+		t.Fatalf("expected message to be created: %d", code)
+	}
+	if code, body := do(t, http.MethodGet, url, ""); code != http.StatusOK || body != "offer" {
+		t.Errorf("unexpected first message: %d %q", code, body)
+	}
+	if code, _ := do(t, http.MethodGet, url, ""); code != http.StatusNotFound {
+		t.Errorf("expected mailbox to be empty until refilled: %d", code)
+	}
+	// the policy is fixed when the mailbox is created
+	if code := put("offer2", InvitePolicy{MaxJoins: 5}); code != http.StatusCreated {
+		t.Fatalf("expected mailbox to be refilled: %d", code)
+	}
+	if code, body := do(t, http.MethodGet, url, ""); code != http.StatusOK || body != "offer2" {
+		t.Errorf("unexpected second message: %d %q", code, body)
+	}
+	if code, _ := do(t, http.MethodGet, url, ""); code != http.StatusGone {
+		t.Errorf("expected mailbox to be used up: %d", code)
+	}
+
+	if code := put("offer", InvitePolicy{MaxJoins: -1}); code != http.StatusGone {
+		t.Errorf("expected used mailbox to stay used: %d", code)
+	}
+	req, _ := http.NewRequest(http.MethodPut, GenMailboxURL(ts.URL), strings.NewReader("offer"))
+	req.Header.Set(MaxJoinsHeader, "none")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected bad policy to be rejected: %d", resp.StatusCode)
+	}
+}
+
+func TestMailboxPlaceholder(t *testing.T) {
+	m := newMailboxes()
+	if _, err := m.take("/m/a", 10*time.Millisecond, nil); err != errEmpty {
+		t.Errorf("expected nothing to arrive, got %v", err)
+	}
+	if n := m.count(); n != 0 {
+		t.Errorf("expected waiting not to leave a mailbox behind, got %d", n)
+	}
+	// the first PUT sets the policy even when a GET is already waiting
+	taken := make(chan error, 1)
+	go func() {
+		_, err := m.take("/m/a", 5*time.Second, nil)
+		taken <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := m.put("/m/a", []byte("offer"), InvitePolicy{ExpiresIn: time.Minute, MaxJoins: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-taken; err != nil {
+		t.Fatal(err)
+	}
+	if err := m.put("/m/a", []byte("offer"), InvitePolicy{ExpiresIn: time.Minute, MaxJoins: 1}); err != nil {
+		t.Errorf("expected second join to be allowed, got %v", err)
+	}
+}
+
+func TestMailboxExpired(t *testing.T) {
+	m := newMailboxes()
+	if err := m.put("/m/a", []byte("offer"), InvitePolicy{ExpiresIn: time.Minute, MaxJoins: 1}); err != nil {
+		t.Fatal(err)
+	}
+	m.boxes["/m/a"].expires = time.Now().Add(-time.Second)
+	if _, err := m.take("/m/a", 0, nil); err != ErrExpired {
+		t.Errorf("expected expired invite, got %v", err)
+	}
+	if err := m.put("/m/a", []byte("offer"), InvitePolicy{ExpiresIn: time.Minute, MaxJoins: 1}); err != ErrExpired {
+		t.Errorf("expected expired invite not to be refilled, got %v", err)
+	}
+	// forgotten some time after expiry
+	m.boxes["/m/a"].expires = time.Now().Add(-2 * tombstoneTTL)
+	if _, err := m.take("/m/a", 0, nil); err != errNotFound {
+		t.Errorf("expected expired invite to be forgotten, got %v", err)
+	}
+}
+
+func TestMailboxWait(t *testing.T) {
+	ts := newTestServer(t)
+	url := GenMailboxURL(ts.URL)
+	if code, _ := do(t, http.MethodGet, url+"?wait=1", ""); code != http.StatusNoContent {
+		t.Errorf("expected no content after waiting: %d", code)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		req, _ := http.NewRequest(http.MethodPut, url, strings.NewReader("answer"))
+		if resp, err := http.DefaultClient.Do(req); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	if code, body := do(t, http.MethodGet, url+"?wait=5", ""); code != http.StatusOK || body != "answer" {
+		t.Errorf("unexpected message: %d %q", code, body)
+	}
+}
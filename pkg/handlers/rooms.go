@@ -0,0 +1,310 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers used to claim and join a room
+const (
+	RoomKeyHeader      = "Pair-Room-Key"
+	RoomPasswordHeader = "Pair-Room-Password"
+	// RoomOwnersHeader lists the key ids of teammates who can also host in the room
+	RoomOwnersHeader = "Pair-Room-Owners"
+)
+
+// roomTTL is how long a room stays claimed by its owner after they last hosted in it
+const roomTTL = 30 * 24 * time.Hour
+
+// maxRoomFailures is the number of wrong passwords a client can give before it is locked out for
+// roomLockout, maxRoomGuesses is the number of wrong passwords for one room from any client before
+// it stops taking guesses for roomLockout
+const (
+	maxRoomFailures = 5
+	maxRoomGuesses  = 20
+	roomLockout     = time.Minute
+)
+
+var (
+	ErrRoomPassword = errors.New("room needs a password")
+	ErrRoomLocked   = errors.New("too many wrong passwords, try again in a minute")
+
+	errRoomOwned  = errors.New("room is owned by someone else")
+	errRoomEmpty  = errors.New("nobody is hosting in this room right now")
+	errRoomAbsent = errors.New("no room with that name")
+)
+
+var roomNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,62}$`)
+
+// RoomURL is the stable address of the room called name on host
+func RoomURL(host, name string) string {
+	u, err := url.Parse(host)
+	if err != nil {
+		panic(fmt.Sprintf("badly formed host provided: %s", host))
+	}
+	u.Path = "/r/" + name
+	return u.String()
+}
+
+// ValidRoomName reports whether name can be used for a room
+func ValidRoomName(name string) bool {
+	return roomNamePattern.MatchString(name)
+}
+
+// RoomKeyID identifies a room key without revealing it, an owner lists a teammate's id
+// so they can host in the room with their own key
+func RoomKeyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:16])
+}
+
+var roomKeyIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// ParseRoomOwners splits a comma separated list of key ids
+func ParseRoomOwners(list string) ([]string, error) {
+	var ids []string
+	for _, id := range strings.Split(list, ",") {
+		id = strings.ToLower(strings.TrimSpace(id))
+		if id == "" {
+			continue
+		}
+		if !roomKeyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("room key ids are 32 hex characters: %q", id)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// room is claimed by whoever first hosts in it, only they and the owners they add can host
+// in it again. Guests joining the room are given the mailbox holding the current offer.
+type room struct {
+	owners   []string
+	password []byte
+	expires  time.Time
+
+	mailbox        string
+	mailboxExpires time.Time
+}
+
+// owned reports whether key is one of the room's owners
+func (r *room) owned(key string) bool {
+	id := []byte(RoomKeyID(key))
+	owned := 0
+	for _, owner := range r.owners {
+		owned |= subtle.ConstantTimeCompare([]byte(owner), id)
+	}
+	return owned == 1
+}
+
+type rooms struct {
+	mu      sync.Mutex
+	rooms   map[string]*room
+	guesses *guesses
+	// roomGuesses are wrong passwords counted by room name rather than client
+	roomGuesses *guesses
+}
+
+func newRooms() *rooms {
+	return &rooms{
+		rooms:       make(map[string]*room),
+		guesses:     newGuesses(maxRoomFailures, roomLockout),
+		roomGuesses: newGuesses(maxRoomGuesses, roomLockout),
+	}
+}
+
+func (rs *rooms) purge(now time.Time) {
+	for name, r := range rs.rooms {
+		if now.After(r.expires) {
+			delete(rs.rooms, name)
+		}
+	}
+}
+
+// host points the room at mailbox, claiming it for key if nobody owns it. The password,
+// when not nil, replaces any set before, an empty password lets anyone with the room's url
+// join. Other owners, when not nil, replace the owners of the room apart from key.
+func (rs *rooms) host(name, key string, password *string, mailbox string, others []string, ttl time.Duration) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	now := time.Now()
+	rs.purge(now)
+	r, ok := rs.rooms[name]
+	if !ok {
+		r = &room{owners: []string{RoomKeyID(key)}}
+		rs.rooms[name] = r
+	}
+	if !r.owned(key) {
+		return errRoomOwned
+	}
+	if others != nil {
+		r.owners = append([]string{RoomKeyID(key)}, others...)
+	}
+	if password != nil {
+		r.password = nil
+		if *password != "" {
+			sum := sha256.Sum256([]byte(*password))
+			r.password = sum[:]
+		}
+	}
+	r.expires = now.Add(roomTTL)
+	r.mailbox = mailbox
+	r.mailboxExpires = now.Add(ttl)
+	return nil
+}
+
+// leave stops pointing the room at an offer, the owner keeps the room
+func (rs *rooms) leave(name, key string) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	r, ok := rs.rooms[name]
+	if !ok {
+		return errRoomAbsent
+	}
+	if !r.owned(key) {
+		return errRoomOwned
+	}
+	r.mailbox = ""
+	return nil
+}
+
+// join returns the mailbox holding the current offer in the room, a client that gives too
+// many wrong passwords is locked out of every room for a while, and a room given too many
+// by any clients stops taking them for a while. Wrong passwords are not forgotten when a
+// client gives a right one, so joining a room of its own does not give it more guesses.
+func (rs *rooms) join(name, password, client string) (string, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	now := time.Now()
+	rs.purge(now)
+	r, ok := rs.rooms[name]
+	if !ok {
+		return "", errRoomAbsent
+	}
+	if r.password != nil {
+		if !rs.guesses.allowed(client, now) || !rs.roomGuesses.allowed(name, now) {
+			return "", ErrRoomLocked
+		}
+		sum := sha256.Sum256([]byte(password))
+		if subtle.ConstantTimeCompare(r.password, sum[:]) != 1 {
+			// an empty password is asking whether one is needed, not a guess
+			if password != "" {
+				rs.guesses.wrong(client, now)
+				rs.roomGuesses.wrong(name, now)
+			}
+			return "", ErrRoomPassword
+		}
+	}
+	if r.mailbox == "" || now.After(r.mailboxExpires) {
+		return "", errRoomEmpty
+	}
+	return r.mailbox, nil
+}
+
+func (rs *rooms) count() int {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return len(rs.rooms)
+}
+
+// roomStatus is the response code for an error using a room
+func roomStatus(err error) int {
+	switch err {
+	case ErrRoomPassword:
+		return http.StatusUnauthorized
+	case ErrRoomLocked:
+		return http.StatusTooManyRequests
+	case errRoomOwned:
+		return http.StatusForbidden
+	}
+	return http.StatusNotFound
+}
+
+// BaseRoomHandler gives a team a stable url to join whoever is hosting. A PUT to /r/<name>
+// with the mailbox url of an offer as the body claims the room for the key in the
+// Pair-Room-Key header, or updates it if that key is one of its owners. A Pair-Room-Owners
+// header replaces the other owners with the listed key ids, and a Pair-Room-Password header
+// restricts the room to guests who know it, an empty one removing it. Without either header
+// the room keeps its owners and password. A GET responds with the path of the mailbox
+// holding the current offer, and a DELETE stops hosting in the room.
+func (s *server) BaseRoomHandler(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/r/")
+	if !ValidRoomName(name) {
+		http.Error(w, "room names are lowercase letters, numbers, '.', '_' and '-'", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		mailbox, err := s.rooms.join(name, r.Header.Get(RoomPasswordHeader), s.clientAddr(r))
+		if err != nil {
+			http.Error(w, err.Error(), roomStatus(err))
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(mailbox))
+	case http.MethodPut:
+		key := r.Header.Get(RoomKeyHeader)
+		if len(key) < 16 {
+			http.Error(w, fmt.Sprintf("%s header should hold a key of at least 16 characters", RoomKeyHeader), http.StatusBadRequest)
+			return
+		}
+		policy, err := parseInvitePolicy(r.Header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var owners []string
+		if list, ok := r.Header[RoomOwnersHeader]; ok {
+			if owners, err = ParseRoomOwners(strings.Join(list, ",")); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if owners == nil {
+				owners = []string{}
+			}
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1024))
+		if err != nil {
+			http.Error(w, "body of request is over 1024 bytes", http.StatusBadRequest)
+			return
+		}
+		// only mailboxes on this server can be hosted in a room, so rooms cannot point anywhere else
+		u, err := url.Parse(strings.TrimSpace(string(body)))
+		if err != nil || !strings.HasPrefix(u.Path, "/m/") {
+			http.Error(w, "body of request should be a mailbox url", http.StatusBadRequest)
+			return
+		}
+		var password *string
+		if _, ok := r.Header[RoomPasswordHeader]; ok {
+			p := r.Header.Get(RoomPasswordHeader)
+			password = &p
+		}
+		if err := s.rooms.host(name, key, password, u.Path, owners, policy.ExpiresIn); err != nil {
+			http.Error(w, err.Error(), roomStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("CREATED\n"))
+	case http.MethodDelete:
+		if err := s.rooms.leave(name, r.Header.Get(RoomKeyHeader)); err != nil {
+			http.Error(w, err.Error(), roomStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK\n"))
+	default:
+		http.Error(w, fmt.Sprintf("unexpected method used: %s", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+}
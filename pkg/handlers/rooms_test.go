@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoom(t *testing.T) {
+	ts := newTestServer(t)
+	room := RoomURL(ts.URL, "backend-team")
+	roomReq := func(method string, headers map[string]string, body string) (int, string) {
+		req, err := http.NewRequest(method, room, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(b))
+	}
+	owner := map[string]string{RoomKeyHeader: "0123456789abcdef"}
+	if code, _ := roomReq(http.MethodGet, nil, ""); code != http.StatusNotFound {
+		t.Errorf("expected unclaimed room to be not found: %d", code)
+	}
+	first := GenMailboxURL(ts.URL)
+	if code, _ := roomReq(http.MethodPut, owner, first); code != http.StatusCreated {
+		t.Fatalf("expected room to be claimed: %d", code)
+	}
+	if code, mailbox := roomReq(http.MethodGet, nil, ""); code != http.StatusOK || ts.URL+mailbox != first {
+		t.Errorf("unexpected offer in room: %d %q", code, mailbox)
+	}
+	if code, _ := roomReq(http.MethodPut, map[string]string{RoomKeyHeader: "fedcba9876543210"}, GenMailboxURL(ts.URL)); code != http.StatusForbidden {
+		t.Errorf("expected room to be kept by its owner: %d", code)
+	}
+
+	// the owner hosts again with a password
+	second := GenMailboxURL(ts.URL)
+	withPassword := map[string]string{RoomKeyHeader: owner[RoomKeyHeader], RoomPasswordHeader: "hunter2"}
+	if code, _ := roomReq(http.MethodPut, withPassword, second); code != http.StatusCreated {
+		t.Fatalf("expected owner to host again: %d", code)
+	}
+	if code, _ := roomReq(http.MethodGet, nil, ""); code != http.StatusUnauthorized {
+		t.Errorf("expected room to need a password: %d", code)
+	}
+	if code, mailbox := roomReq(http.MethodGet, map[string]string{RoomPasswordHeader: "hunter2"}, ""); code != http.StatusOK || ts.URL+mailbox != second {
+		t.Errorf("unexpected offer in room: %d %q", code, mailbox)
+	}
+	// hosting again without the header keeps the password, an empty one removes it
+	if code, _ := roomReq(http.MethodPut, owner, second); code != http.StatusCreated {
+		t.Fatalf("expected owner to host again: %d", code)
+	}
+	if code, _ := roomReq(http.MethodGet, nil, ""); code != http.StatusUnauthorized {
+		t.Errorf("expected room to keep its password: %d", code)
+	}
+	if code, _ := roomReq(http.MethodPut, map[string]string{RoomKeyHeader: owner[RoomKeyHeader], RoomPasswordHeader: ""}, second); code != http.StatusCreated {
+		t.Fatalf("expected owner to remove the password: %d", code)
+	}
+	if code, _ := roomReq(http.MethodGet, nil, ""); code != http.StatusOK {
+		t.Errorf("expected room without a password: %d", code)
+	}
+	if code, _ := roomReq(http.MethodPut, withPassword, second); code != http.StatusCreated {
+		t.Fatalf("expected owner to set the password again: %d", code)
+	}
+	for i := 0; i < maxRoomFailures; i++ {
+		roomReq(http.MethodGet, map[string]string{RoomPasswordHeader: "guess"}, "")
+	}
+	if code, _ := roomReq(http.MethodGet, map[string]string{RoomPasswordHeader: "hunter2"}, ""); code != http.StatusTooManyRequests {
+		t.Errorf("expected room to stop accepting guesses: %d", code)
+	}
+
+	// the owner lets a teammate host with their own key, then takes it away again
+	teammate := map[string]string{RoomKeyHeader: "fedcba9876543210"}
+	if code, _ := roomReq(http.MethodPut, map[string]string{RoomKeyHeader: owner[RoomKeyHeader], RoomOwnersHeader: RoomKeyID(teammate[RoomKeyHeader])}, second); code != http.StatusCreated {
+		t.Fatalf("expected owner to add a teammate: %d", code)
+	}
+	if code, body := roomReq(http.MethodPut, teammate, GenMailboxURL(ts.URL)); code != http.StatusCreated {
+		t.Errorf("expected teammate to host: %d %q", code, body)
+	}
+	if code, _ := roomReq(http.MethodPut, map[string]string{RoomKeyHeader: owner[RoomKeyHeader], RoomOwnersHeader: ""}, second); code != http.StatusCreated {
+		t.Fatalf("expected owner to remove teammates: %d", code)
+	}
+	if code, _ := roomReq(http.MethodPut, teammate, GenMailboxURL(ts.URL)); code != http.StatusForbidden {
+		t.Errorf("expected removed teammate not to host: %d", code)
+	}
+	if code, _ := roomReq(http.MethodPut, map[string]string{RoomKeyHeader: owner[RoomKeyHeader], RoomOwnersHeader: "not-an-id"}, second); code != http.StatusBadRequest {
+		t.Errorf("expected bad key id to be refused: %d", code)
+	}
+
+	if code, _ := roomReq(http.MethodDelete, owner, ""); code != http.StatusOK {
+		t.Errorf("expected owner to stop hosting: %d", code)
+	}
+	if code, body := roomReq(http.MethodPut, owner, GenMailboxURL(ts.URL)); code != http.StatusCreated {
+		t.Errorf("expected owner to keep room after leaving: %d %q", code, body)
+	}
+}
+
+func TestValidRoomName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"backend-team": true,
+		"team.a_1":     true,
+		"":             false,
+		"-team":        false,
+		"Team":         false,
+		"a/b":          false,
+	} {
+		if got := ValidRoomName(name); got != want {
+			t.Errorf("%q: got %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRoomGuessesByClient(t *testing.T) {
+	rs := newRooms()
+	password := "hunter2"
+	if err := rs.host("team", "0123456789abcdef", &password, "/m/x", nil, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rs.join("team", "", "192.0.2.1"); err != ErrRoomPassword {
+		t.Errorf("expected room to ask for a password: %v", err)
+	}
+	for i := 0; i < maxRoomFailures; i++ {
+		if _, err := rs.join("team", "guess", "192.0.2.1"); err != ErrRoomPassword {
+			t.Errorf("expected wrong password: %v", err)
+		}
+	}
+	if _, err := rs.join("team", "hunter2", "192.0.2.1"); err != ErrRoomLocked {
+		t.Errorf("expected client to be locked out: %v", err)
+	}
+	if mailbox, err := rs.join("team", "hunter2", "192.0.2.2"); err != nil || mailbox != "/m/x" {
+		t.Errorf("expected another client to join: %q %v", mailbox, err)
+	}
+}
+
+func TestRoomGuessesNotForgotten(t *testing.T) {
+	rs := newRooms()
+	own, other := "open-sesame", "hunter2"
+	if err := rs.host("mine", "0123456789abcdef", &own, "/m/x", nil, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.host("team", "fedcba9876543210", &other, "/m/y", nil, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxRoomFailures-1; i++ {
+		if _, err := rs.join("team", "guess", "192.0.2.1"); err != ErrRoomPassword {
+			t.Errorf("expected wrong password: %v", err)
+		}
+	}
+	if _, err := rs.join("mine", own, "192.0.2.1"); err != nil {
+		t.Errorf("expected client to join its own room: %v", err)
+	}
+	if _, err := rs.join("team", "guess", "192.0.2.1"); err != ErrRoomPassword {
+		t.Errorf("expected wrong password: %v", err)
+	}
+	if _, err := rs.join("team", other, "192.0.2.1"); err != ErrRoomLocked {
+		t.Errorf("expected joining its own room not to give the client more guesses: %v", err)
+	}
+}
+
+func TestRoomGuessesByRoom(t *testing.T) {
+	rs := newRooms()
+	password := "hunter2"
+	if err := rs.host("team", "0123456789abcdef", &password, "/m/x", nil, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxRoomGuesses; i++ {
+		// each guess from a new address, as an attacker with many addresses would
+		if _, err := rs.join("team", "guess", fmt.Sprintf("198.51.100.%d", i)); err != ErrRoomPassword {
+			t.Errorf("expected wrong password: %v", err)
+		}
+	}
+	if _, err := rs.join("team", password, "192.0.2.2"); err != ErrRoomLocked {
+		t.Errorf("expected room to stop taking guesses after %d: %v", maxRoomGuesses, err)
+	}
+	rs.roomGuesses.purge(time.Now().Add(roomLockout + time.Second))
+	if _, err := rs.join("team", password, "192.0.2.2"); err != nil {
+		t.Errorf("expected room to take guesses again after the lockout: %v", err)
+	}
+}
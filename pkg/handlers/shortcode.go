@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bottlerocketlabs/pair/pkg/wordlist"
+)
+
+// CodeSecretHeader carries the words of a short code, so they stay out of access logs
+const CodeSecretHeader = "Pair-Code-Secret"
+
+// codeWords is the number of words in a short code, a code is burnt after maxSlotAttempts
+// wrong guesses at it so an attacker has at most a 10 in 4 million chance of joining
+const codeWords = 2
+
+// maxCodeAttempts is the number of wrong guesses a client can make before it is locked out
+// for codeLockout, other clients can still resolve codes. maxSlotAttempts is the number of
+// wrong guesses at one code, from any client, before it is burnt.
+const (
+	maxCodeAttempts = 3
+	maxSlotAttempts = 10
+	codeLockout     = 10 * time.Minute
+)
+
+// maxCodeRegistrations is the number of short codes a client can register every
+// registrationWindow, so one client cannot hold every slot
+const (
+	maxCodeRegistrations = 10
+	registrationWindow   = time.Hour
+)
+
+// MaxCodeTTL is the longest a short code lasts, an invite that lasts longer needs a new code
+const MaxCodeTTL = time.Hour
+
+// maxCodeSlots is the number of short codes that can be registered at once
+const maxCodeSlots = 9999
+
+var (
+	ErrCodeLocked = errors.New("too many wrong guesses, try again later")
+
+	errCodeNotFound = errors.New("no invite with that code")
+	errCodeWrong    = errors.New("wrong code")
+	errCodeBurnt    = errors.New("too many wrong guesses at this code, ask your host for a new one")
+	errCodesFull    = errors.New("no short codes available, try again later")
+	errTooManyCodes = errors.New("too many short codes registered, try again later")
+)
+
+var shortCodePattern = regexp.MustCompile(`^([0-9]{1,4})-([a-z]+(?:-[a-z]+)*)$`)
+
+// NewShortCodeSecret returns the words for a short code
+func NewShortCodeSecret() (string, error) {
+	return wordlist.Random(codeWords)
+}
+
+// ParseShortCode splits a code like 7-crossover-clockwork into its slot and secret words
+func ParseShortCode(code string) (int, string, bool) {
+	m := shortCodePattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(code)))
+	if m == nil {
+		return 0, "", false
+	}
+	slot, err := strconv.Atoi(m[1])
+	if err != nil || slot < 1 {
+		return 0, "", false
+	}
+	for _, w := range strings.Split(m[2], "-") {
+		if !wordlist.Contains(w) {
+			return 0, "", false
+		}
+	}
+	return slot, m[2], true
+}
+
+// ShortCodeURL is where the short code in slot is resolved on host, slot 0 is where codes are registered
+func ShortCodeURL(host string, slot int) string {
+	u, err := url.Parse(host)
+	if err != nil {
+		panic(fmt.Sprintf("badly formed host provided: %s", host))
+	}
+	u.Path = "/c/"
+	if slot > 0 {
+		u.Path += strconv.Itoa(slot)
+	}
+	return u.String()
+}
+
+// shortCode points at a mailbox until it expires or too many wrong guesses are made at it
+type shortCode struct {
+	secret  string
+	mailbox string
+	expires time.Time
+	wrong   int
+}
+
+type shortCodes struct {
+	mu      sync.Mutex
+	slots   map[int]*shortCode
+	guesses *guesses
+	// registrations are counted against a client like wrong guesses
+	registrations *guesses
+}
+
+func newShortCodes() *shortCodes {
+	return &shortCodes{
+		slots:         make(map[int]*shortCode),
+		guesses:       newGuesses(maxCodeAttempts, codeLockout),
+		registrations: newGuesses(maxCodeRegistrations, registrationWindow),
+	}
+}
+
+func (c *shortCodes) purge(now time.Time) {
+	for slot, code := range c.slots {
+		if now.After(code.expires) {
+			delete(c.slots, slot)
+		}
+	}
+}
+
+// register stores a code for mailbox by client in the lowest free slot, keeping the numbers
+// short, it lasts for ttl up to MaxCodeTTL
+func (c *shortCodes) register(secret, mailbox, client string, ttl time.Duration) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if !c.registrations.allowed(client, now) {
+		return 0, errTooManyCodes
+	}
+	c.registrations.wrong(client, now)
+	c.purge(now)
+	if ttl > MaxCodeTTL {
+		ttl = MaxCodeTTL
+	}
+	for slot := 1; slot <= maxCodeSlots; slot++ {
+		if _, ok := c.slots[slot]; ok {
+			continue
+		}
+		c.slots[slot] = &shortCode{secret: secret, mailbox: mailbox, expires: now.Add(ttl)}
+		return slot, nil
+	}
+	return 0, errCodesFull
+}
+
+// resolve returns the mailbox for the code in slot if secret matches, guesses at unknown
+// slots count as wrong so a client cannot find out which slots are in use for free. Wrong
+// guesses are not forgotten when a client gets one right, so resolving a code of its own
+// does not give it more guesses at others.
+func (c *shortCodes) resolve(slot int, secret, client string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	if !c.guesses.allowed(client, now) {
+		return "", ErrCodeLocked
+	}
+	c.purge(now)
+	code, ok := c.slots[slot]
+	if !ok {
+		c.guesses.wrong(client, now)
+		return "", errCodeNotFound
+	}
+	if code.wrong >= maxSlotAttempts {
+		return "", errCodeBurnt
+	}
+	if subtle.ConstantTimeCompare([]byte(code.secret), []byte(secret)) != 1 {
+		c.guesses.wrong(client, now)
+		code.wrong++
+		return "", errCodeWrong
+	}
+	return code.mailbox, nil
+}
+
+func (c *shortCodes) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.slots)
+}
+
+// BaseShortCodeHandler gives mailboxes short codes that can be read aloud. A POST to /c/
+// with the mailbox URL as the body and the code's words in the Pair-Code-Secret header
+// responds with the slot number, a GET to /c/<slot> with the words responds with the
+// mailbox path. A client is locked out for 10 minutes after 3 wrong guesses, and a code
+// is burnt after 10 wrong guesses at it. A client can register 10 codes an hour, each
+// lasting up to an hour.
+func (s *server) BaseShortCodeHandler(w http.ResponseWriter, r *http.Request) {
+	secret := r.Header.Get(CodeSecretHeader)
+	if secret == "" || len(secret) > 100 {
+		http.Error(w, fmt.Sprintf("%s header should hold the words of the code", CodeSecretHeader), http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		if r.URL.Path != "/c/" {
+			http.Error(w, "404 page not found", http.StatusNotFound)
+			return
+		}
+		policy, err := parseInvitePolicy(r.Header)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1024))
+		if err != nil {
+			http.Error(w, "body of request is over 1024 bytes", http.StatusBadRequest)
+			return
+		}
+		// only mailboxes on this server can be given a code, so codes cannot point anywhere else
+		u, err := url.Parse(strings.TrimSpace(string(body)))
+		if err != nil || !strings.HasPrefix(u.Path, "/m/") {
+			http.Error(w, "body of request should be a mailbox url", http.StatusBadRequest)
+			return
+		}
+		slot, err := s.shortCodes.register(secret, u.Path, s.clientAddr(r), policy.ExpiresIn)
+		switch err {
+		case nil:
+		case errTooManyCodes:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		default:
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintf(w, "%d\n", slot)
+	case http.MethodGet:
+		slot, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/c/"))
+		if err != nil {
+			http.Error(w, "404 page not found", http.StatusNotFound)
+			return
+		}
+		mailbox, err := s.shortCodes.resolve(slot, strings.ToLower(secret), s.clientAddr(r))
+		switch err {
+		case nil:
+		case ErrCodeLocked:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case errCodeWrong:
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case errCodeBurnt:
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		default:
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(mailbox))
+	default:
+		http.Error(w, fmt.Sprintf("unexpected method used: %s", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+}
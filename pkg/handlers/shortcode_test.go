@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseShortCode(t *testing.T) {
+	for code, want := range map[string]struct {
+		slot   int
+		secret string
+		ok     bool
+	}{
+		"7-abandon-zoo":        {7, "abandon-zoo", true},
+		" 12-Abandon-Zoo\n":    {12, "abandon-zoo", true},
+		"0-abandon-zoo":        {0, "", false},
+		"7-abandon-notaword":   {0, "", false},
+		"http://example.com/m": {0, "", false},
+		"12345-abandon-zoo":    {0, "", false},
+	} {
+		slot, secret, ok := ParseShortCode(code)
+		if slot != want.slot || secret != want.secret || ok != want.ok {
+			t.Errorf("%q: got %d %q %v, want %+v", code, slot, secret, ok, want)
+		}
+	}
+}
+
+func TestShortCode(t *testing.T) {
+	ts := newTestServer(t)
+	codeReq := func(method string, slot int, secret, body string) (int, string) {
+		req, err := http.NewRequest(method, ShortCodeURL(ts.URL, slot), strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(CodeSecretHeader, secret)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, strings.TrimSpace(string(b))
+	}
+	if code, _ := codeReq(http.MethodPost, 0, "abandon-zoo", "https://evil.example.com/phish"); code != http.StatusBadRequest {
+		t.Errorf("expected code for another url to be refused: %d", code)
+	}
+	mailbox := GenMailboxURL(ts.URL)
+	code, slot := codeReq(http.MethodPost, 0, "abandon-zoo", mailbox)
+	if code != http.StatusCreated || slot != "1" {
+		t.Fatalf("unexpected registration: %d %q", code, slot)
+	}
+	if code, slot := codeReq(http.MethodPost, 0, "ability-able", GenMailboxURL(ts.URL)); code != http.StatusCreated || slot != "2" {
+		t.Errorf("expected next free slot: %d %q", code, slot)
+	}
+	if code, path := codeReq(http.MethodGet, 1, "abandon-zoo", ""); code != http.StatusOK || ts.URL+path != mailbox {
+		t.Errorf("unexpected resolution: %d %q", code, path)
+	}
+	if code, _ := codeReq(http.MethodGet, 3, "abandon-zoo", ""); code != http.StatusNotFound {
+		t.Errorf("expected unknown slot to be not found: %d", code)
+	}
+	for i := 1; i < maxCodeAttempts; i++ {
+		if code, _ := codeReq(http.MethodGet, 1, "abandon-able", ""); code != http.StatusForbidden {
+			t.Errorf("expected wrong guess to be refused: %d", code)
+		}
+	}
+	if code, body := codeReq(http.MethodGet, 1, "abandon-able", ""); code != http.StatusTooManyRequests || body != ErrCodeLocked.Error() {
+		t.Errorf("expected client to be locked out after %d guesses: %d %q", maxCodeAttempts, code, body)
+	}
+	if code, _ := codeReq(http.MethodGet, 1, "abandon-zoo", ""); code != http.StatusTooManyRequests {
+		t.Errorf("expected locked out client not to resolve: %d", code)
+	}
+}
+
+func TestShortCodeGuessesByClient(t *testing.T) {
+	codes := newShortCodes()
+	slot, err := codes.register("abandon-zoo", "/m/x", "192.0.2.9", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxCodeAttempts; i++ {
+		if _, err := codes.resolve(slot, "abandon-able", "192.0.2.1"); err != errCodeWrong {
+			t.Errorf("expected wrong guess: %v", err)
+		}
+	}
+	if _, err := codes.resolve(slot+1, "abandon-zoo", "192.0.2.1"); err != ErrCodeLocked {
+		t.Errorf("expected client to be locked out of every slot: %v", err)
+	}
+	if mailbox, err := codes.resolve(slot, "abandon-zoo", "192.0.2.2"); err != nil || mailbox != "/m/x" {
+		t.Errorf("expected another client to resolve the code: %q %v", mailbox, err)
+	}
+	codes.guesses.purge(time.Now().Add(codeLockout + time.Second))
+	if mailbox, err := codes.resolve(slot, "abandon-zoo", "192.0.2.1"); err != nil || mailbox != "/m/x" {
+		t.Errorf("expected client to resolve the code after the lockout: %q %v", mailbox, err)
+	}
+}
+
+func TestShortCodeGuessesNotForgotten(t *testing.T) {
+	codes := newShortCodes()
+	own, err := codes.register("abandon-zoo", "/m/own", "192.0.2.1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := codes.register("ability-able", "/m/other", "192.0.2.2", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxCodeAttempts-1; i++ {
+		if _, err := codes.resolve(other, "abandon-able", "192.0.2.1"); err != errCodeWrong {
+			t.Errorf("expected wrong guess: %v", err)
+		}
+	}
+	if _, err := codes.resolve(own, "abandon-zoo", "192.0.2.1"); err != nil {
+		t.Errorf("expected client to resolve its own code: %v", err)
+	}
+	if _, err := codes.resolve(other, "abandon-able", "192.0.2.1"); err != errCodeWrong {
+		t.Errorf("expected wrong guess: %v", err)
+	}
+	if _, err := codes.resolve(other, "abandon-about", "192.0.2.1"); err != ErrCodeLocked {
+		t.Errorf("expected resolving its own code not to give the client more guesses: %v", err)
+	}
+}
+
+func TestShortCodeBurnt(t *testing.T) {
+	codes := newShortCodes()
+	slot, err := codes.register("abandon-zoo", "/m/x", "192.0.2.1", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < maxSlotAttempts; i++ {
+		// each guess from a new address, as an attacker with many addresses would
+		if _, err := codes.resolve(slot, "abandon-able", fmt.Sprintf("198.51.100.%d", i)); err != errCodeWrong {
+			t.Errorf("expected wrong guess: %v", err)
+		}
+	}
+	if _, err := codes.resolve(slot, "abandon-zoo", "192.0.2.2"); err != errCodeBurnt {
+		t.Errorf("expected code to be burnt after %d wrong guesses: %v", maxSlotAttempts, err)
+	}
+}
+
+func TestShortCodeRegistrations(t *testing.T) {
+	codes := newShortCodes()
+	for i := 0; i < maxCodeRegistrations; i++ {
+		if _, err := codes.register("abandon-zoo", "/m/x", "192.0.2.1", time.Minute); err != nil {
+			t.Errorf("expected code to be registered: %v", err)
+		}
+	}
+	if _, err := codes.register("abandon-zoo", "/m/x", "192.0.2.1", time.Minute); err != errTooManyCodes {
+		t.Errorf("expected client to be limited to %d codes: %v", maxCodeRegistrations, err)
+	}
+	slot, err := codes.register("abandon-zoo", "/m/x", "192.0.2.2", 24*time.Hour)
+	if err != nil {
+		t.Fatalf("expected another client to register a code: %v", err)
+	}
+	if expires := time.Until(codes.slots[slot].expires); expires > MaxCodeTTL {
+		t.Errorf("expected code to last at most %s, got %s", MaxCodeTTL, expires)
+	}
+}
+
+func TestClientAddr(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/c/1", nil)
+	r.RemoteAddr = "10.0.0.1:4000"
+	r.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.7")
+	s := NewServer(log.New(ioutil.Discard, "", 0), time.Minute)
+	if addr := s.clientAddr(r); addr != "10.0.0.1" {
+		t.Errorf("expected remote address, got %q", addr)
+	}
+	s.BehindProxy = true
+	if addr := s.clientAddr(r); addr != "198.51.100.7" {
+		t.Errorf("expected address added by the proxy, got %q", addr)
+	}
+	r.Header.Set("X-Forwarded-For", "2001:db8:1:2:aaaa::1")
+	if addr := s.clientAddr(r); addr != "2001:db8:1:2::/64" {
+		t.Errorf("expected IPv6 address to be keyed on its /64, got %q", addr)
+	}
+	s.BehindProxy = false
+	r.RemoteAddr = "[2001:db8:1:2:bbbb::7]:4000"
+	if addr := s.clientAddr(r); addr != "2001:db8:1:2::/64" {
+		t.Errorf("expected IPv6 address to be keyed on its /64, got %q", addr)
+	}
+}
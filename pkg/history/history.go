@@ -0,0 +1,134 @@
+// Package history keeps a local record of pairing sessions as JSON lines.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bottlerocketlabs/pair/pkg/config"
+)
+
+// Roles a session was joined in
+const (
+	RoleHost  = "host"
+	RoleGuest = "guest"
+)
+
+// Entry is one session, from when the guest connected until they left. The candidate types
+// are those of the connection's candidate pair, such as host, srflx or relay.
+type Entry struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	Role            string    `json:"role"`
+	Participants    []string  `json:"participants"`
+	BytesSent       uint64    `json:"bytes_sent"`
+	BytesReceived   uint64    `json:"bytes_received"`
+	LocalCandidate  string    `json:"local_candidate,omitempty"`
+	RemoteCandidate string    `json:"remote_candidate,omitempty"`
+	ExitReason      string    `json:"exit_reason"`
+}
+
+// Duration is how long the session lasted
+func (e Entry) Duration() time.Duration {
+	return e.End.Sub(e.Start)
+}
+
+// DefaultPath is where history is kept unless another file is chosen
+func DefaultPath() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+// Append adds e to the history at path, creating it readable only by the user
+func Append(path string, e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("could not marshal history entry: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("could not open history: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("could not write history: %w", err)
+	}
+	return nil
+}
+
+// Read returns the entries in the history at path, oldest first. There are none if it does
+// not exist yet, lines that cannot be read are skipped.
+func Read(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open history: %w", err)
+	}
+	defer f.Close()
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return entries, fmt.Errorf("could not read history: %w", err)
+	}
+	return entries, nil
+}
+
+// Filter picks entries, its zero value picks all of them
+type Filter struct {
+	// Since and Until bound when a session started
+	Since, Until time.Time
+	// Role is RoleHost or RoleGuest
+	Role string
+	// With is part of the name of one of the participants, ignoring case
+	With string
+}
+
+// Match reports whether f picks e
+func (f Filter) Match(e Entry) bool {
+	if !f.Since.IsZero() && e.Start.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !e.Start.Before(f.Until) {
+		return false
+	}
+	if f.Role != "" && e.Role != f.Role {
+		return false
+	}
+	if f.With == "" {
+		return true
+	}
+	for _, p := range e.Participants {
+		if strings.Contains(strings.ToLower(p), strings.ToLower(f.With)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Select returns the entries f picks
+func (f Filter) Select(entries []Entry) []Entry {
+	var out []Entry
+	for _, e := range entries {
+		if f.Match(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
@@ -0,0 +1,60 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pair-history-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.jsonl")
+	if entries, err := Read(path); err != nil || len(entries) != 0 {
+		t.Fatalf("expected no history yet, got %v %v", entries, err)
+	}
+	start := time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+	written := []Entry{
+		{Start: start, End: start.Add(time.Hour), Role: RoleHost, Participants: []string{"me@box", "Alice <alice@example.com>"}, BytesSent: 10, ExitReason: "guest left"},
+		{Start: start.Add(24 * time.Hour), End: start.Add(25 * time.Hour), Role: RoleGuest, Participants: []string{"bob@box"}, RemoteCandidate: "relay", ExitReason: "host ended the session"},
+	}
+	for _, e := range written {
+		if err := Append(path, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(entries, written) {
+		t.Errorf("read back %+v, expected %+v", entries, written)
+	}
+	if d := entries[0].Duration(); d != time.Hour {
+		t.Errorf("expected an hour, got %s", d)
+	}
+
+	tests := []struct {
+		filter   Filter
+		expected []Entry
+	}{
+		{Filter{}, written},
+		{Filter{Since: start.Add(time.Hour)}, written[1:]},
+		{Filter{Until: start.Add(time.Hour)}, written[:1]},
+		{Filter{Role: RoleGuest}, written[1:]},
+		{Filter{With: "alice"}, written[:1]},
+		{Filter{With: "carol"}, nil},
+	}
+	for _, test := range tests {
+		if got := test.filter.Select(entries); !cmp.Equal(got, test.expected) {
+			t.Errorf("%+v: got %+v, expected %+v", test.filter, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+// Package sandbox runs a command where a guest cannot reach the rest of the host's files.
+//
+// The command is started by re-executing the current binary as the init process of new
+// user, mount and pid namespaces (and optionally network), as nobody and with only an
+// allowlisted environment. Init hides the configured directories behind empty tmpfs mounts,
+// mounts the project directory and any exposed directories back on top, drops its
+// capabilities for children and then runs the command, reaping everything it leaves
+// behind. Binaries using this package must call MaybeInit at the start of main.
+package sandbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// initArg is passed as the first argument when re-executing as init
+const initArg = "__pair-sandbox-init"
+
+// configEnv holds the Config for init, it is removed before the command runs
+const configEnv = "PAIR_SANDBOX_CONFIG"
+
+// TempDirs are where sockets for the host's other processes usually live, such as tmux's,
+// ssh-agent's and the session bus, hide them along with the home directory
+var TempDirs = []string{"/tmp", "/var/tmp", "/run/user"}
+
+// keepEnv are the environment variables passed into the sandbox, anything else such as
+// SSH_AUTH_SOCK, TMUX or DBUS_SESSION_BUS_ADDRESS could point outside it
+var keepEnv = map[string]bool{
+	"PATH": true, "HOME": true, "USER": true, "LOGNAME": true, "SHELL": true,
+	"TERM": true, "COLORTERM": true, "LANG": true, "LANGUAGE": true, "TZ": true,
+	"EDITOR": true, "VISUAL": true, "PAGER": true,
+}
+
+// environ is the part of environ allowed into the sandbox
+func environ(environ []string) []string {
+	var kept []string
+	for _, kv := range environ {
+		k := strings.SplitN(kv, "=", 2)[0]
+		if keepEnv[k] || strings.HasPrefix(k, "LC_") {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
+
+// ErrUnsupported is returned on platforms without the namespaces needed
+var ErrUnsupported = errors.New("sandbox is only supported on linux")
+
+type Config struct {
+	// ProjectDir stays visible and writable, the command starts in it
+	ProjectDir string `json:"project_dir"`
+	// Hide are directories replaced with empty ones, usually the home directory and TempDirs,
+	// those that do not exist are skipped
+	Hide []string `json:"hide"`
+	// Expose are directories mounted back inside hidden ones, such as a tmux socket directory
+	Expose []string `json:"expose"`
+	// Network keeps access to the host's network, otherwise only a loopback device exists
+	Network bool `json:"network"`
+}
+
+// Validate makes the paths absolute and checks the project and exposed dirs are not themselves hidden
+func (c *Config) Validate() error {
+	dir, err := filepath.Abs(c.ProjectDir)
+	if err != nil {
+		return fmt.Errorf("could not find project directory: %w", err)
+	}
+	c.ProjectDir = filepath.Clean(dir)
+	for i, e := range c.Expose {
+		e, err := filepath.Abs(e)
+		if err != nil {
+			return fmt.Errorf("could not find directory to expose: %w", err)
+		}
+		c.Expose[i] = filepath.Clean(e)
+	}
+	for i, h := range c.Hide {
+		h, err := filepath.Abs(h)
+		if err != nil {
+			return fmt.Errorf("could not find directory to hide: %w", err)
+		}
+		c.Hide[i] = filepath.Clean(h)
+		if contains(c.ProjectDir, c.Hide[i]) {
+			return fmt.Errorf("project directory %s would expose hidden directory %s, run from a subdirectory", c.ProjectDir, c.Hide[i])
+		}
+		for _, e := range c.Expose {
+			if contains(e, c.Hide[i]) {
+				return fmt.Errorf("%s would expose hidden directory %s", e, c.Hide[i])
+			}
+		}
+	}
+	return nil
+}
+
+// contains reports whether path is dir or inside it
+func contains(dir, path string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+func (c Config) encode() (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal sandbox config: %w", err)
+	}
+	return string(b), nil
+}
+
+// MaybeInit runs init and exits if this process was started as a sandbox init,
+// otherwise it returns immediately
+func MaybeInit() {
+	if len(os.Args) < 2 || os.Args[1] != initArg {
+		return
+	}
+	var cfg Config
+	if err := json.Unmarshal([]byte(os.Getenv(configEnv)), &cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: could not read config: %s\n", err)
+		os.Exit(1)
+	}
+	os.Unsetenv(configEnv)
+	if err := runInit(cfg, os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: %s\n", err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
@@ -0,0 +1,138 @@
+//go:build linux
+// +build linux
+
+package sandbox
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+const (
+	capSetPCap  = 8
+	capSysAdmin = 21
+
+	// nobody is the overflow uid and gid
+	nobody = 65534
+
+	prSetNoNewPrivs  = 38
+	prSetSecurebits  = 28
+	prCapAmbient     = 47
+	prCapAmbientDrop = 4
+
+	// SECBIT_NOROOT, SECBIT_NO_SETUID_FIXUP and SECBIT_KEEP_CAPS_LOCKED with their locks,
+	// so even a uid 0 child gains no capabilities when it execs
+	securebits = 1<<0 | 1<<1 | 1<<2 | 1<<3 | 1<<5
+)
+
+// Command prepares args to run inside the sandbox, like exec.Command the caller starts it
+func Command(cfg Config, args []string) (*exec.Cmd, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	encoded, err := cfg.encode()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command("/proc/self/exe", append([]string{initArg}, args...)...)
+	cmd.Env = append(environ(os.Environ()), configEnv+"="+encoded)
+	flags := syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC
+	if !cfg.Network {
+		flags |= syscall.CLONE_NEWNET
+	}
+	// the host's user is nobody inside, so nothing can tell it is the same user
+	uid, gid := os.Getuid(), os.Getgid()
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:                 uintptr(flags),
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: nobody, HostID: uid, Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: nobody, HostID: gid, Size: 1}},
+		GidMappingsEnableSetgroups: false,
+		AmbientCaps:                []uintptr{capSetPCap, capSysAdmin},
+		Pdeathsig:                  syscall.SIGKILL,
+	}
+	return cmd, nil
+}
+
+func runInit(cfg Config, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command to run")
+	}
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("could not make mounts private: %w", err)
+	}
+	// keep hold of the project and exposed directories so they can be mounted back once
+	// their parents are hidden
+	keep := append([]string{cfg.ProjectDir}, cfg.Expose...)
+	kept := make([]*os.File, len(keep))
+	for i, dir := range keep {
+		f, err := os.Open(dir)
+		if err != nil {
+			return fmt.Errorf("could not open %s: %w", dir, err)
+		}
+		defer f.Close()
+		kept[i] = f
+	}
+	for _, dir := range cfg.Hide {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+		if err := syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0700"); err != nil {
+			return fmt.Errorf("could not hide %s: %w", dir, err)
+		}
+	}
+	for i, dir := range keep {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("could not create mount point for %s: %w", dir, err)
+		}
+		source := fmt.Sprintf("/proc/self/fd/%d", kept[i].Fd())
+		if err := syscall.Mount(source, dir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("could not mount %s: %w", dir, err)
+		}
+	}
+	// a fresh /proc only shows processes in the sandbox, older kernels may refuse it
+	_ = syscall.Mount("proc", "/proc", "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "")
+	if !cfg.Network {
+		_ = exec.Command("ip", "link", "set", "lo", "up").Run()
+	}
+	if err := os.Chdir(cfg.ProjectDir); err != nil {
+		return fmt.Errorf("could not change to project directory: %w", err)
+	}
+	if err := dropPrivileges(); err != nil {
+		return err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("could not start %v: %w", args, err)
+	}
+	// as pid 1 everything orphaned in the sandbox, such as a daemonised tmux server, is ours
+	// to reap, the sandbox ends when the last of them exits
+	for {
+		var status syscall.WaitStatus
+		_, err := syscall.Wait4(-1, &status, 0, nil)
+		if err == syscall.ECHILD {
+			return nil
+		}
+		if err != nil && err != syscall.EINTR {
+			return fmt.Errorf("could not wait for children: %w", err)
+		}
+	}
+}
+
+// dropPrivileges stops anything started from now on gaining capabilities,
+// which would let it unmount the tmpfs hiding the host's files
+func dropPrivileges() error {
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientDrop, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("could not clear ambient capabilities: %w", errno)
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetSecurebits, securebits, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("could not set securebits: %w", errno)
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("could not set no new privileges: %w", errno)
+	}
+	return nil
+}
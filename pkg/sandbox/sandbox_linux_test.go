@@ -0,0 +1,124 @@
+//go:build linux
+// +build linux
+
+package sandbox
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	MaybeInit()
+	os.Exit(m.Run())
+}
+
+func TestValidate(t *testing.T) {
+	cfg := Config{ProjectDir: "/home/user", Hide: []string{"/home/user"}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error when project dir is hidden")
+	}
+	cfg = Config{ProjectDir: "/home", Hide: []string{"/home/user"}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error when project dir contains a hidden dir")
+	}
+	cfg = Config{ProjectDir: "/home/user/project", Hide: []string{"/home/user", "/tmp/pair/x"}, Expose: []string{"/tmp/pair"}}
+	if err := cfg.Validate(); err == nil {
+		t.Errorf("expected error when an exposed dir contains a hidden dir")
+	}
+	cfg = Config{ProjectDir: "/home/user/src/../project", Hide: []string{"/home/user/"}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cfg.ProjectDir != "/home/user/project" || cfg.Hide[0] != "/home/user" {
+		t.Errorf("paths not cleaned: %+v", cfg)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	home := t.TempDir()
+	project := filepath.Join(home, "project")
+	if err := os.Mkdir(project, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(home, "secret"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(project, "code"), []byte("code"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := Config{ProjectDir: project, Hide: []string{home}}
+	cmd, err := Command(cfg, []string{"sh", "-c", "pwd; ls -A " + home + "; cat code; echo; umount " + home + " 2>/dev/null || echo hidden"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Skipf("namespaces not available: %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("sandbox failed: %v: %s", err, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	want := []string{project, "project", "code", "hidden"}
+	if strings.Join(lines, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+}
+
+func TestSandboxHidesSockets(t *testing.T) {
+	tmp, err := ioutil.TempDir("/tmp", "pair-sandbox-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// stand ins for the host's tmux server and ssh-agent, and the isolated tmux server's directory
+	hostTmux := filepath.Join(tmp, "tmux-1000", "default")
+	agent := filepath.Join(tmp, "ssh-agent", "agent.1")
+	isolated := filepath.Join(tmp, "pair-isolated")
+	project := filepath.Join(tmp, "project")
+	for _, dir := range []string{filepath.Dir(hostTmux), filepath.Dir(agent), isolated, project} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, socket := range []string{hostTmux, agent, filepath.Join(isolated, "socket")} {
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+	}
+	os.Setenv("SSH_AUTH_SOCK", agent)
+	os.Setenv("TMUX", hostTmux+",1,0")
+	defer os.Unsetenv("SSH_AUTH_SOCK")
+	defer os.Unsetenv("TMUX")
+	cfg := Config{ProjectDir: project, Hide: TempDirs, Expose: []string{isolated}}
+	script := "for s in " + hostTmux + " " + agent + " " + isolated + "/socket; do test -S $s && echo $s; done; " +
+		"echo ssh=$SSH_AUTH_SOCK tmux=$TMUX; id -u"
+	cmd, err := Command(cfg, []string{"sh", "-c", script})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Start(); err != nil {
+		t.Skipf("namespaces not available: %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("sandbox failed: %v: %s", err, stderr.String())
+	}
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	want := []string{isolated + "/socket", "ssh= tmux=", "65534"}
+	if strings.Join(lines, ",") != strings.Join(want, ",") {
+		t.Errorf("got %q, want %q", lines, want)
+	}
+}
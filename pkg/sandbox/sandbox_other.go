@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package sandbox
+
+import (
+	"os/exec"
+)
+
+// Command prepares args to run inside the sandbox, like exec.Command the caller starts it
+func Command(cfg Config, args []string) (*exec.Cmd, error) {
+	return nil, ErrUnsupported
+}
+
+func runInit(cfg Config, args []string) error {
+	return ErrUnsupported
+}
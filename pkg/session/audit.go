@@ -0,0 +1,86 @@
+package session
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bottlerocketlabs/pair/pkg/audit"
+)
+
+// audit records input from the guest in the AuditLog, before it is written to the pty
+func (hs *HostSession) audit(kind string, input []byte) error {
+	if hs.AuditLog == nil {
+		return nil
+	}
+	e := audit.Entry{
+		Time:     time.Now().UTC(),
+		Host:     hs.Name,
+		Guest:    hs.guestName,
+		GuestKey: sdpFingerprint(hs.AnswerSD.SDP),
+		Kind:     kind,
+		Input:    string(input),
+		Bytes:    len(input),
+	}
+	if hs.AuditRedact && hs.guestEchoOff() {
+		e.Input = ""
+		e.Redacted = true
+	}
+	return hs.AuditLog.Record(e)
+}
+
+// guestEchoOff reports whether the pane the guest is typing into has echo turned off,
+// when it cannot tell it assumes so to keep passwords out of the audit log
+func (hs *HostSession) guestEchoOff() bool {
+	tty, err := hs.guestPaneTTY()
+	if err != nil {
+		hs.Debug.Printf("redacting input, could not find the guest's pane: %s", err)
+		return true
+	}
+	off, err := ttyEchoOff(tty)
+	if err != nil {
+		hs.Debug.Printf("redacting input: %s", err)
+		return true
+	}
+	return off
+}
+
+// guestPaneTTY is the tty of the pane the guest is typing into, it only asks tmux when
+// the guest's window or its active pane has changed since it last did
+func (hs *HostSession) guestPaneTTY() (string, error) {
+	target := hs.GuestWindow()
+	if target == "" {
+		target = hs.TmuxSession
+	}
+	hs.tmuxMu.Lock()
+	if hs.guestTTY != "" && hs.guestTTYTarget == target {
+		defer hs.tmuxMu.Unlock()
+		return hs.guestTTY, nil
+	}
+	changes := hs.paneChanges
+	hs.tmuxMu.Unlock()
+	var tty string
+	if hs.control != nil {
+		lines, err := hs.control.Command("display-message", "-p", "-t", target, "#{pane_tty}")
+		if err != nil || len(lines) != 1 {
+			return "", fmt.Errorf("could not get tty of %s: %q: %w", target, lines, err)
+		}
+		tty = lines[0]
+	} else {
+		var err error
+		if tty, err = hs.sharedTmux().Display(target, "#{pane_tty}"); err != nil {
+			return "", err
+		}
+	}
+	hs.tmuxMu.Lock()
+	if hs.paneChanges == changes {
+		hs.guestTTY, hs.guestTTYTarget = tty, target
+	}
+	hs.tmuxMu.Unlock()
+	return tty, nil
+}
+
+// forgetGuestTTY makes guestPaneTTY ask tmux again, call it with tmuxMu held
+func (hs *HostSession) forgetGuestTTY() {
+	hs.guestTTY = ""
+	hs.paneChanges++
+}
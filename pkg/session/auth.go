@@ -0,0 +1,167 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bottlerocketlabs/pair/pkg/random"
+	"github.com/bottlerocketlabs/pair/pkg/sshkeys"
+	"golang.org/x/crypto/ssh"
+)
+
+// authTimeout is how long a guest has to prove who they are, long enough to confirm the
+// use of a key with their ssh-agent
+const authTimeout = time.Minute
+
+// maxAuthTries is the number of keys a guest can offer before they are turned away
+const maxAuthTries = 6
+
+var errNotAuthorized = errors.New("guest is not authorized")
+
+// authSignature is a signature of a challenge by the guest's key
+type authSignature struct {
+	Key    []byte `json:"key"`
+	Format string `json:"format"`
+	Blob   []byte `json:"blob"`
+}
+
+// authPayload is what the guest signs, it includes the certificate fingerprints of both ends
+// of the connection so a signature cannot be replayed to another host
+func authPayload(challenge, hostSDP, guestSDP string) []byte {
+	return []byte(fmt.Sprintf("pair-guest-auth-v1\n%s\n%s\n%s\n", challenge, sdpFingerprint(hostSDP), sdpFingerprint(guestSDP)))
+}
+
+// authorizeGuest asks the guest to sign a challenge with one of the AuthorizedKeys, returning
+// the name of the key they signed with. Like ssh, the guest signs with one key at a time and
+// is asked for another until one is accepted, so the host never lists the keys it accepts
+// and only learns of keys the guest holds.
+func (hs *HostSession) authorizeGuest() (string, error) {
+	nonce, err := random.Bytes(32)
+	if err != nil {
+		return "", err
+	}
+	challenge := base64.StdEncoding.EncodeToString(nonce)
+	msg, _ := json.Marshal([]string{"auth_challenge", challenge})
+	if err := hs.DataChannel.SendText(string(msg)); err != nil {
+		return "", fmt.Errorf("could not send challenge: %w", err)
+	}
+	payload := authPayload(challenge, hs.OfferSD.SDP, hs.AnswerSD.SDP)
+	timeout := time.After(authTimeout)
+	for tries := 1; ; tries++ {
+		var sig authSignature
+		select {
+		case sig = <-hs.authChan:
+		case <-timeout:
+			hs.rejectGuest("timed out waiting for a signature from your ssh-agent")
+			return "", fmt.Errorf("%w: no signature within %s", errNotAuthorized, authTimeout)
+		}
+		if name, ok := hs.verifySignature(sig, payload); ok {
+			msg, _ := json.Marshal([]string{"auth_ok", name})
+			if err := hs.DataChannel.SendText(string(msg)); err != nil {
+				return "", fmt.Errorf("could not accept guest: %w", err)
+			}
+			return name, nil
+		}
+		if tries == maxAuthTries {
+			hs.rejectGuest("none of the keys you signed in with are authorized by the host, choose one with -auth-key")
+			return "", fmt.Errorf("%w: none of the %d keys offered are authorized", errNotAuthorized, tries)
+		}
+		msg, _ := json.Marshal([]string{"auth_retry"})
+		if err := hs.DataChannel.SendText(string(msg)); err != nil {
+			return "", fmt.Errorf("could not ask guest for another key: %w", err)
+		}
+	}
+}
+
+// verifySignature returns the name of the authorized key sig is from, if it is a good
+// signature of payload
+func (hs *HostSession) verifySignature(sig authSignature, payload []byte) (string, bool) {
+	key, err := ssh.ParsePublicKey(sig.Key)
+	if err != nil {
+		return "", false
+	}
+	authorized, ok := hs.AuthorizedKeys.Find(key)
+	if !ok {
+		hs.Debug.Printf("guest key %s is not authorized", ssh.FingerprintSHA256(key))
+		return "", false
+	}
+	if err := sshkeys.Verify(key, payload, &ssh.Signature{Format: sig.Format, Blob: sig.Blob}); err != nil {
+		hs.Debug.Printf("bad signature from %s: %s", authorized.Name, err)
+		return "", false
+	}
+	return authorized.Name, true
+}
+
+func (hs *HostSession) rejectGuest(reason string) {
+	msg, _ := json.Marshal([]string{"auth_failed", reason})
+	if err := hs.DataChannel.SendText(string(msg)); err != nil {
+		hs.Debug.Printf("could not reject guest: %s", err)
+		return
+	}
+	// give the guest a moment to read the reason before hanging up
+	time.Sleep(time.Second)
+}
+
+// answerChallenge signs the host's challenge with the guest's AuthKey from their ssh-agent, or
+// the key after the ones already tried
+func (cs *ClientSession) answerChallenge(challenge string, tried int) error {
+	ag, conn, err := sshkeys.Agent()
+	if err != nil {
+		return fmt.Errorf("host needs you to sign in with an ssh key: %w", err)
+	}
+	defer conn.Close()
+	signers, err := sshkeys.AgentSigners(ag)
+	if err != nil {
+		return err
+	}
+	signer, err := authSigner(signers, cs.AuthKey, tried)
+	if err != nil {
+		return err
+	}
+	sig, err := signChallenge(signer, authPayload(challenge, cs.OfferSD.SDP, cs.AnswerSD.SDP))
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(sig)
+	if err != nil {
+		return fmt.Errorf("could not marshal signature: %w", err)
+	}
+	msg, _ := json.Marshal([]string{"auth_response", string(b)})
+	if err := cs.DataChannel.SendText(string(msg)); err != nil {
+		return fmt.Errorf("could not send signature: %w", err)
+	}
+	return nil
+}
+
+// authSigner picks the key to sign in with after tried keys were not accepted, key when it
+// is set or the next of signers
+func authSigner(signers []ssh.Signer, key ssh.PublicKey, tried int) (ssh.Signer, error) {
+	if key != nil {
+		if tried > 0 {
+			return nil, fmt.Errorf("key %s is not authorized by the host", ssh.FingerprintSHA256(key))
+		}
+		for _, s := range signers {
+			if bytes.Equal(s.PublicKey().Marshal(), key.Marshal()) {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("key %s is not in ssh-agent", ssh.FingerprintSHA256(key))
+	}
+	if tried < len(signers) {
+		return signers[tried], nil
+	}
+	return nil, errors.New("none of the keys in your ssh-agent are authorized by the host")
+}
+
+// signChallenge signs payload with signer
+func signChallenge(signer ssh.Signer, payload []byte) (authSignature, error) {
+	sig, err := sshkeys.Sign(signer, payload)
+	if err != nil {
+		return authSignature{}, fmt.Errorf("could not sign with %s: %w", ssh.FingerprintSHA256(signer.PublicKey()), err)
+	}
+	return authSignature{Key: signer.PublicKey().Marshal(), Format: sig.Format, Blob: sig.Blob}, nil
+}
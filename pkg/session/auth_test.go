@@ -0,0 +1,86 @@
+package session
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"log"
+	"testing"
+
+	"github.com/bottlerocketlabs/pair/pkg/sshkeys"
+	"golang.org/x/crypto/ssh"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestVerifySignature(t *testing.T) {
+	alice, eve := newSigner(t), newSigner(t)
+	hs := HostSession{AuthorizedKeys: sshkeys.AuthorizedKeys{{Key: alice.PublicKey(), Name: "alice"}}}
+	hs.Debug = log.New(ioutil.Discard, "", 0)
+	payload := authPayload("challenge", "a=fingerprint:sha-256 AA\r\n", "a=fingerprint:sha-256 BB\r\n")
+
+	sig, err := signChallenge(alice, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, ok := hs.verifySignature(sig, payload); !ok || name != "alice" {
+		t.Errorf("expected alice to be let in: %q %v", name, ok)
+	}
+	evil, err := signChallenge(eve, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := hs.verifySignature(evil, payload); ok {
+		t.Errorf("expected eve not to be let in")
+	}
+	// a signature for another connection is not accepted
+	other := authPayload("challenge", "a=fingerprint:sha-256 AA\r\n", "a=fingerprint:sha-256 CC\r\n")
+	if _, ok := hs.verifySignature(sig, other); ok {
+		t.Errorf("expected signature to be bound to the connection")
+	}
+}
+
+func TestAuthSigner(t *testing.T) {
+	alice, bob, eve := newSigner(t), newSigner(t), newSigner(t)
+	signers := []ssh.Signer{eve, bob, alice}
+	tests := []struct {
+		name  string
+		key   ssh.PublicKey
+		tried int
+		want  ssh.Signer
+	}{
+		{name: "first key in the agent", want: eve},
+		{name: "next key in the agent", tried: 1, want: bob},
+		{name: "every key tried", tried: 3},
+		{name: "chosen key", key: alice.PublicKey(), want: alice},
+		{name: "chosen key the host does not accept", key: alice.PublicKey(), tried: 1},
+		{name: "chosen key not in the agent", key: newSigner(t).PublicKey()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := authSigner(signers, tt.key, tt.tried)
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("expected an error, got %s", ssh.FingerprintSHA256(got.PublicKey()))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", ssh.FingerprintSHA256(tt.want.PublicKey()), ssh.FingerprintSHA256(got.PublicKey()))
+			}
+		})
+	}
+}
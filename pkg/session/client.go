@@ -3,19 +3,48 @@ package session
 import (
 	"bufio"
 	"bytes"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
+	"time"
 
+	"github.com/bottlerocketlabs/pair/pkg/handlers"
+	"github.com/bottlerocketlabs/pair/pkg/history"
+	"github.com/bottlerocketlabs/pair/pkg/sshkeys"
 	"github.com/kr/pty"
 	"github.com/pion/webrtc/v2"
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/term"
 )
 
 type ClientSession struct {
 	Session
 	OfferURL string
+	// RoomPassword is sent when OfferURL is a room, the guest is asked for it otherwise
+	RoomPassword string
+	// Predict is one of PredictAdaptive, PredictAlways or PredictNever
+	Predict string
+	// AllowedSigners are the keys trusted to sign invites, invites are not checked when it is nil
+	AllowedSigners sshkeys.AllowedSigners
+	// AuthKey is the key in the guest's ssh-agent they sign in to hosts with, each key in it
+	// is offered in turn until the host accepts one when it is nil
+	AuthKey ssh.PublicKey
+	// Name and Email are sent to the host to credit the guest in commits made while pairing
+	Name  string
+	Email string
+
+	sizeMu    sync.Mutex
+	size      Size
+	predictor *predictor
+	started   time.Time
+	snapshot  *os.File
+	// room is the room the guest joined through, if any
+	room string
 }
 
 func (cs *ClientSession) Run() error {
@@ -23,6 +52,10 @@ func (cs *ClientSession) Run() error {
 	if err != nil {
 		return fmt.Errorf("could not init client session: %w", err)
 	}
+	if cs.IsTerminal && cs.Predict != "" && cs.Predict != PredictNever {
+		cs.predictor = newPredictor(cs.Stdout, cs.Predict)
+		cs.started = time.Now()
+	}
 	maxPacketLifeTime := uint16(1000) // arbitrary
 	ordered := true
 	cs.Debug.Printf("creating data channel")
@@ -38,6 +71,22 @@ func (cs *ClientSession) Run() error {
 	cs.DataChannel.OnClose(cs.dataChannelOnClose())
 	cs.Debug.Printf("data channel setup")
 
+	if isRoomURL(cs.OfferURL) {
+		invite, err := cs.resolveRoom(cs.OfferURL)
+		if err != nil {
+			return err
+		}
+		cs.room = cs.OfferURL
+		cs.Debug.Printf("resolved room to %s", invite)
+		cs.OfferURL = invite
+	} else if slot, secret, ok := handlers.ParseShortCode(cs.OfferURL); ok {
+		invite, err := cs.resolveCode(slot, secret)
+		if err != nil {
+			return err
+		}
+		cs.Debug.Printf("resolved short code to %s", invite)
+		cs.OfferURL = invite
+	}
 	body, err := cs.getSDP(cs.OfferURL)
 	if err != nil {
 		return fmt.Errorf("could not get sdp from server: %w", err)
@@ -51,6 +100,12 @@ func (cs *ClientSession) Run() error {
 	}
 	cs.Debug.Printf("decoded offer: %+v", offerSD)
 	cs.OfferSD = offerSD
+	if err := cs.checkInviteSignature(); err != nil {
+		return err
+	}
+	if err := cs.checkHostIdentity(); err != nil {
+		return err
+	}
 	offer := webrtc.SessionDescription{
 		Type: webrtc.SDPTypeOffer,
 		SDP:  cs.OfferSD.SDP,
@@ -72,6 +127,7 @@ func (cs *ClientSession) Run() error {
 	answerSD := SessionDescription{
 		SDP: answer.SDP,
 	}
+	cs.AnswerSD = answerSD
 	encodedAnswer, err := answerSD.Encode()
 	if err != nil {
 		return fmt.Errorf("could not encode answer: %w", err)
@@ -80,12 +136,17 @@ func (cs *ClientSession) Run() error {
 	if cs.OfferSD.SDPAnswerURI == "" {
 		return fmt.Errorf("no uri provided to upload answer")
 	}
-	if err := cs.putSDP(cs.OfferSD.SDPAnswerURI, bytes.NewBuffer([]byte(encodedAnswer))); err != nil {
+	if err := cs.putSDP(cs.OfferSD.SDPAnswerURI, bytes.NewBuffer([]byte(encodedAnswer)), handlers.InvitePolicy{}); err != nil {
 		return fmt.Errorf("could not upload SDP answer: %w", err)
 	}
 	cs.Debug.Printf("answer uploaded, waiting for connection")
 	// wait here to quit
 	err = <-cs.ErrorChan
+	cs.recordHistory(history.RoleGuest, cs.participants(), exitReason(err, "session ended"))
+	if errors.Is(err, errConnectionClosed) {
+		// the host has gone, there is nobody to tell we are quitting
+		return cs.restoreTerminalState()
+	}
 	if err != nil {
 		return fmt.Errorf("recieved error from error channel: %w", err)
 	}
@@ -96,6 +157,18 @@ func (cs *ClientSession) Run() error {
 	return nil
 }
 
+// participants are the host and the guest, as far as the guest knows them
+func (cs *ClientSession) participants() []string {
+	host := cs.OfferSD.Host
+	if host == "" {
+		host = "host"
+	}
+	if cs.Name == "" {
+		return []string{host}
+	}
+	return []string{host, cs.Name}
+}
+
 func sendTermSize(term *os.File, dcSend func(s string) error) error {
 	winSize, err := pty.GetsizeFull(term)
 	if err != nil {
@@ -113,11 +186,26 @@ func (cs *ClientSession) dataChannelOnOpen() func() {
 	return func() {
 		cs.Debug.Printf("Data channel '%s'-'%d'='%d' open.\n", cs.DataChannel.Label(), cs.DataChannel.ID(), cs.DataChannel.MaxPacketLifeTime())
 		cs.Debug.Println("Terminal session started")
+		cs.connected = time.Now()
+		h := NewHello(os.Environ())
+		h.Name, h.Email = cs.Name, cs.Email
+		hello, err := h.Encode()
+		if err != nil {
+			cs.ErrorChan <- err
+			return
+		}
+		if err := cs.DataChannel.SendText(hello); err != nil {
+			cs.ErrorChan <- fmt.Errorf("could not send hello: %w", err)
+			return
+		}
 
 		if err := cs.makeRawTerminal(); err != nil {
 			cs.ErrorChan <- fmt.Errorf("could not make raw terminal: %w", err)
 			return
 		}
+		if cs.predictor != nil {
+			go cs.measureRTT()
+		}
 
 		ch := make(chan os.Signal, 1)
 		signal.Notify(ch, syscall.SIGWINCH)
@@ -128,6 +216,7 @@ func (cs *ClientSession) dataChannelOnOpen() func() {
 					cs.ErrorChan <- fmt.Errorf("could not send terminal size: %w", err)
 					return
 				}
+				cs.drawLetterbox()
 			}
 		}()
 		ch <- syscall.SIGWINCH // initial resize
@@ -143,13 +232,96 @@ func (cs *ClientSession) dataChannelOnOpen() func() {
 				cs.ErrorChan <- fmt.Errorf("could not send buffer over data channel: %w", err)
 				return
 			}
+			if cs.predictor != nil {
+				if err := cs.predictor.Input(buf[0:nr]); err != nil {
+					cs.ErrorChan <- fmt.Errorf("could not write prediction: %w", err)
+					return
+				}
+			}
 		}
 	}
 }
 
 func (cs *ClientSession) dataChannelOnMessage() func(msg webrtc.DataChannelMessage) {
+	// the challenge is signed with each key in turn until the host accepts one
+	var challenge string
+	var tried int
 	return func(p webrtc.DataChannelMessage) {
 		if p.IsString {
+			if len(p.Data) > 2 && p.Data[0] == '[' && p.Data[1] == '"' {
+				var msg []interface{}
+				if err := json.Unmarshal(p.Data, &msg); err != nil || len(msg) == 0 {
+					cs.ErrorChan <- fmt.Errorf("could not unmarshal json message: %s", p.Data)
+					return
+				}
+				if msg[0] == "size" {
+					size, err := parseSizeMessage(p.Data)
+					if err != nil || len(size) < 3 {
+						cs.ErrorChan <- fmt.Errorf("could not unmarshal json 'size' message: %s", p.Data)
+						return
+					}
+					cs.sizeMu.Lock()
+					cs.size = Size{Rows: size[1], Cols: size[2]}
+					cs.sizeMu.Unlock()
+					cs.Debug.Printf("shared terminal is %s", cs.size)
+					cs.drawLetterbox()
+					return
+				}
+				if msg[0] == "snapshot" && len(msg) == 2 {
+					chunk, _ := msg[1].(string)
+					if err := cs.writeSnapshot(chunk); err != nil {
+						cs.ErrorChan <- err
+					}
+					return
+				}
+				if msg[0] == "snapshot_end" {
+					if err := cs.endSnapshot(); err != nil {
+						cs.ErrorChan <- err
+					}
+					return
+				}
+				if (msg[0] == "auth_challenge" && len(msg) >= 2) || (msg[0] == "auth_retry" && challenge != "") {
+					if msg[0] == "auth_challenge" {
+						challenge, _ = msg[1].(string)
+						tried = 0
+					} else {
+						tried++
+					}
+					challenge, tried := challenge, tried
+					go func() {
+						if err := cs.answerChallenge(challenge, tried); err != nil {
+							cs.ErrorChan <- err
+						}
+					}()
+					return
+				}
+				if msg[0] == "auth_ok" && len(msg) == 2 {
+					_, _ = fmt.Fprintf(cs.Stderr, "[pair] host let you in as %v\r\n", msg[1])
+					return
+				}
+				if msg[0] == "auth_failed" && len(msg) == 2 {
+					cs.ErrorChan <- fmt.Errorf("host did not let you in: %v", msg[1])
+					return
+				}
+				if msg[0] == "rejected" && len(msg) == 2 {
+					// the host shows the reason in tmux, the bell draws attention to it
+					cs.Debug.Printf("host rejected input: %v", msg[1])
+					_, _ = cs.Stdout.Write([]byte("\a"))
+					return
+				}
+				if msg[0] == "pong" && len(msg) == 2 && cs.predictor != nil {
+					sent, _ := msg[1].(string)
+					us, err := strconv.ParseInt(sent, 10, 64)
+					if err != nil {
+						cs.Debug.Printf("ignoring malformed pong: %s", p.Data)
+						return
+					}
+					cs.predictor.SetRTT(time.Since(cs.started) - time.Duration(us)*time.Microsecond)
+					return
+				}
+				cs.Debug.Printf("ignoring unknown message: %s", p.Data)
+				return
+			}
 			if string(p.Data) == "quit" {
 				if cs.IsTerminal {
 					term.Restore(int(cs.Stdin.Fd()), cs.OldTerminalState)
@@ -157,18 +329,98 @@ func (cs *ClientSession) dataChannelOnMessage() func(msg webrtc.DataChannelMessa
 				cs.ErrorChan <- nil
 				return
 			}
-			cs.ErrorChan <- fmt.Errorf("unexpected string message: %s", string(p.Data))
+			// newer hosts may send messages this guest does not know about
+			cs.Debug.Printf("ignoring unknown message: %s", p.Data)
 		} else {
-			f := bufio.NewWriter(cs.Stdout)
-			f.Write(p.Data)
-			f.Flush()
+			if cs.predictor != nil {
+				glitch, err := cs.predictor.Output(p.Data)
+				if err != nil {
+					cs.ErrorChan <- fmt.Errorf("could not write output: %w", err)
+					return
+				}
+				if glitch {
+					cs.requestRedraw()
+				}
+			} else {
+				f := bufio.NewWriter(cs.Stdout)
+				f.Write(p.Data)
+				f.Flush()
+			}
+			if bytes.Contains(p.Data, []byte("\x1b[2J")) || bytes.Contains(p.Data, []byte("\x1b[J")) {
+				cs.drawLetterbox()
+			}
+		}
+	}
+}
+
+// measureRTT pings the host to decide whether to predict typing, and takes back
+// predictions the host has not echoed in time
+func (cs *ClientSession) measureRTT() {
+	ping := time.NewTicker(2 * time.Second)
+	defer ping.Stop()
+	tick := time.NewTicker(100 * time.Millisecond)
+	defer tick.Stop()
+	send := func() bool {
+		us := time.Since(cs.started).Microseconds()
+		if err := cs.DataChannel.SendText(fmt.Sprintf(`["ping","%d"]`, us)); err != nil {
+			cs.Debug.Printf("could not send ping: %s", err)
+			return false
+		}
+		return true
+	}
+	if !send() {
+		return
+	}
+	for {
+		select {
+		case <-ping.C:
+			if !send() {
+				return
+			}
+			cs.Debug.Printf("round trip time to host: %s", cs.predictor.RTT())
+		case <-tick.C:
+			glitch, err := cs.predictor.Tick()
+			if err != nil {
+				return
+			}
+			if glitch {
+				cs.requestRedraw()
+			}
 		}
 	}
 }
 
+// requestRedraw asks the host to redraw our terminal after showing a wrong prediction
+func (cs *ClientSession) requestRedraw() {
+	if err := cs.DataChannel.SendText(`["redraw"]`); err != nil {
+		cs.Debug.Printf("could not request redraw: %s", err)
+	}
+}
+
+// drawLetterbox marks the edge of the shared terminal when ours is larger than it
+func (cs *ClientSession) drawLetterbox() {
+	if !cs.IsTerminal {
+		return
+	}
+	cs.sizeMu.Lock()
+	agreed := cs.size
+	cs.sizeMu.Unlock()
+	ws, err := pty.GetsizeFull(cs.Stdin)
+	if err != nil {
+		return
+	}
+	if box := letterbox(agreed, Size{Rows: ws.Rows, Cols: ws.Cols}); box != "" {
+		_, _ = cs.Stdout.WriteString(box)
+	}
+}
+
+// errConnectionClosed ends the session when the host goes away without saying so
+var errConnectionClosed = errors.New("connection closed by host")
+
 func (cs *ClientSession) dataChannelOnClose() func() {
 	return func() {
 		cs.Debug.Printf("data channel closed")
+		cs.finish(errConnectionClosed)
 	}
 }
 
@@ -0,0 +1,104 @@
+package session
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// coauthorHook is installed as every git hook in the shared session, as core.hooksPath can
+// only point at one directory. It runs the repository's own hook of the same name, then for
+// prepare-commit-msg credits everyone listed in the coauthors file next to it.
+const coauthorHook = `#!/bin/sh
+# installed by pair to credit guests in commits made while pairing
+hook="$(basename "$0")"
+coauthors="$(dirname "$0")/coauthors"
+unset GIT_CONFIG_COUNT GIT_CONFIG_KEY_0 GIT_CONFIG_VALUE_0
+hooks="$(git config core.hooksPath || echo "$(git rev-parse --git-common-dir)/hooks")"
+if [ "$hook" != prepare-commit-msg ]; then
+	[ -x "$hooks/$hook" ] || exit 0
+	exec "$hooks/$hook" "$@"
+fi
+if [ -x "$hooks/$hook" ]; then
+	"$hooks/$hook" "$@" || exit
+fi
+[ -s "$coauthors" ] || exit 0
+while IFS= read -r coauthor; do
+	git interpret-trailers --in-place --if-exists addIfDifferent --trailer "Co-authored-by: $coauthor" "$1" || exit
+done < "$coauthors"
+`
+
+// gitHooks are the hooks coauthorHook passes on to the repository's own. It leaves out hooks
+// that change what git does just by being there, such as push-to-checkout.
+var gitHooks = []string{
+	"applypatch-msg", "pre-applypatch", "post-applypatch",
+	"pre-commit", "pre-merge-commit", "prepare-commit-msg", "commit-msg", "post-commit",
+	"pre-rebase", "post-checkout", "post-merge", "pre-push", "post-rewrite",
+	"pre-receive", "update", "post-receive", "post-update", "reference-transaction",
+	"pre-auto-gc", "sendemail-validate", "post-index-change",
+}
+
+// coauthorEnv points git in the shared session at coauthorHook, it needs git 2.31 or later
+func coauthorEnv(hooksDir string) map[string]string {
+	return map[string]string{
+		"GIT_CONFIG_COUNT":   "1",
+		"GIT_CONFIG_KEY_0":   "core.hooksPath",
+		"GIT_CONFIG_VALUE_0": hooksDir,
+	}
+}
+
+// startCoauthors installs coauthorHook for commits made in the shared session. Only windows
+// and panes opened after it are covered, as tmux gives the environment to new ones.
+func (hs *HostSession) startCoauthors() error {
+	dir, err := ioutil.TempDir("", "pair-hooks-")
+	if err != nil {
+		return fmt.Errorf("could not create hooks directory: %w", err)
+	}
+	hs.hooksDir = dir
+	for _, hook := range gitHooks {
+		if err := ioutil.WriteFile(filepath.Join(dir, hook), []byte(coauthorHook), 0700); err != nil {
+			return fmt.Errorf("could not install %s hook: %w", hook, err)
+		}
+	}
+	return hs.setCoauthorEnv(hs.TmuxSession)
+}
+
+// stopCoauthors removes coauthorHook, shells that still point at it commit as usual
+func (hs *HostSession) stopCoauthors() {
+	if hs.hooksDir == "" {
+		return
+	}
+	for k := range coauthorEnv(hs.hooksDir) {
+		_, _ = hs.sharedTmux().Run("set-environment", "-u", "-t", hs.TmuxSession, k)
+	}
+	if err := os.RemoveAll(hs.hooksDir); err != nil {
+		hs.Debug.Printf("could not remove hooks directory: %s", err)
+	}
+	hs.hooksDir = ""
+}
+
+// setCoauthorEnv makes windows opened in session use coauthorHook
+func (hs *HostSession) setCoauthorEnv(session string) error {
+	for k, v := range coauthorEnv(hs.hooksDir) {
+		if _, err := hs.sharedTmux().Run("set-environment", "-t", session, k, v); err != nil {
+			return fmt.Errorf("could not set %s in %s: %w", k, session, err)
+		}
+	}
+	return nil
+}
+
+// setCoauthors credits coauthors in commits from now on
+func (hs *HostSession) setCoauthors(coauthors []string) {
+	if hs.hooksDir == "" {
+		return
+	}
+	var b strings.Builder
+	for _, c := range coauthors {
+		b.WriteString(c + "\n")
+	}
+	if err := ioutil.WriteFile(filepath.Join(hs.hooksDir, "coauthors"), []byte(b.String()), 0600); err != nil {
+		hs.Debug.Printf("could not update coauthors: %s", err)
+	}
+}
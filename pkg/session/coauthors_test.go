@@ -0,0 +1,83 @@
+package session
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCoauthorHook(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("no git")
+	}
+	repo, err := ioutil.TempDir("", "pair-repo-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(repo)
+	hooksDir, err := ioutil.TempDir("", "pair-hooks-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(hooksDir)
+	for _, hook := range gitHooks {
+		if err := ioutil.WriteFile(filepath.Join(hooksDir, hook), []byte(coauthorHook), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(hooksDir, "coauthors"), []byte("Alice <alice@example.com>\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	git := func(env []string, args ...string) (string, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = repo
+		cmd.Env = append(append(os.Environ(), "GIT_AUTHOR_NAME=host", "GIT_AUTHOR_EMAIL=host@example.com",
+			"GIT_COMMITTER_NAME=host", "GIT_COMMITTER_EMAIL=host@example.com"), env...)
+		b, err := cmd.CombinedOutput()
+		return string(b), err
+	}
+	if out, err := git(nil, "init", "-q"); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	// the repository's own hooks keep running: pre-commit records that it ran and
+	// commit-msg adds a trailer of its own
+	own := filepath.Join(repo, ".git", "hooks")
+	hooks := map[string]string{
+		"pre-commit": "#!/bin/sh\ntouch \"$(git rev-parse --git-dir)/pre-commit-ran\"\n",
+		"commit-msg": "#!/bin/sh\ngit interpret-trailers --in-place --trailer 'Reviewed-by: Bob <bob@example.com>' \"$1\"\n",
+	}
+	for name, hook := range hooks {
+		if err := ioutil.WriteFile(filepath.Join(own, name), []byte(hook), 0700); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var env []string
+	for k, v := range coauthorEnv(hooksDir) {
+		env = append(env, k+"="+v)
+	}
+	if out, err := git(env, "commit", "-q", "--allow-empty", "-m", "pairing"); err != nil {
+		t.Fatalf("%s: %s", err, out)
+	}
+	msg, err := git(nil, "log", "-1", "--format=%B")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, trailer := range []string{"Co-authored-by: Alice <alice@example.com>", "Reviewed-by: Bob <bob@example.com>"} {
+		if !strings.Contains(msg, trailer) {
+			t.Errorf("expected %q in commit message:\n%s", trailer, msg)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(repo, ".git", "pre-commit-ran")); err != nil {
+		t.Errorf("expected the repository's pre-commit hook to run: %s", err)
+	}
+	// a failing hook of the repository's still stops the commit
+	if err := ioutil.WriteFile(filepath.Join(own, "pre-commit"), []byte("#!/bin/sh\nexit 1\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := git(env, "commit", "-q", "--allow-empty", "-m", "blocked"); err == nil {
+		t.Errorf("expected the repository's pre-commit hook to stop the commit")
+	}
+}
@@ -0,0 +1,27 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package session
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ttyEchoOff reports whether the terminal at path is reading a line with echo turned off, as
+// it is while a program reads a password. Line editors such as readline also turn echo off,
+// but read a key at a time and echo it themselves.
+func ttyEchoOff(path string) (bool, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|unix.O_NOCTTY, 0)
+	if err != nil {
+		return false, fmt.Errorf("could not open tty: %w", err)
+	}
+	defer f.Close()
+	termios, err := unix.IoctlGetTermios(int(f.Fd()), getTermios)
+	if err != nil {
+		return false, fmt.Errorf("could not get terminal settings of %s: %w", path, err)
+	}
+	return termios.Lflag&unix.ECHO == 0 && termios.Lflag&unix.ICANON != 0, nil
+}
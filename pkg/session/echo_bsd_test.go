@@ -0,0 +1,8 @@
+//go:build darwin || freebsd || netbsd || openbsd
+// +build darwin freebsd netbsd openbsd
+
+package session
+
+import "golang.org/x/sys/unix"
+
+const setTermios = unix.TIOCSETA
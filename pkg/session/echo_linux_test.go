@@ -0,0 +1,8 @@
+//go:build linux
+// +build linux
+
+package session
+
+import "golang.org/x/sys/unix"
+
+const setTermios = unix.TCSETS
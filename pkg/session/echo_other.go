@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package session
+
+import "errors"
+
+func ttyEchoOff(path string) (bool, error) {
+	return false, errors.New("cannot tell if echo is off on this platform")
+}
@@ -0,0 +1,53 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package session
+
+import (
+	"testing"
+
+	"github.com/kr/pty"
+	"golang.org/x/sys/unix"
+)
+
+func TestTTYEchoOff(t *testing.T) {
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		t.Skipf("no pty: %s", err)
+	}
+	defer ptmx.Close()
+	defer tty.Close()
+	fd := int(tty.Fd())
+	termios, err := unix.IoctlGetTermios(fd, getTermios)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name  string
+		echo  bool
+		canon bool
+		off   bool
+	}{
+		{"cooked", true, true, false},
+		{"password", false, true, true},
+		{"line editor", false, false, false},
+	} {
+		termios.Lflag &^= unix.ECHO | unix.ICANON
+		if tc.echo {
+			termios.Lflag |= unix.ECHO
+		}
+		if tc.canon {
+			termios.Lflag |= unix.ICANON
+		}
+		if err := unix.IoctlSetTermios(fd, setTermios, termios); err != nil {
+			t.Fatal(err)
+		}
+		off, err := ttyEchoOff(tty.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if off != tc.off {
+			t.Errorf("%s: expected echo off to be %v", tc.name, tc.off)
+		}
+	}
+}
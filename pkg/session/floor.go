@@ -0,0 +1,295 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bottlerocketlabs/pair/pkg/tmux"
+)
+
+// driveKey hands the keyboard over when pressed by the driver, and asks for it otherwise.
+// It toggles the client's read-only flag, as switch-client is all a read-only client can run.
+const driveKey = "D"
+
+// driverStatus shows who is driving in the status line, in front of what was there before
+const driverStatus = "#{?@pair-driver,#[reverse] #{@pair-driver} is driving#{?@pair-mob, (" + mobStatus + "),} #[default] ,}"
+
+// floor decides whose input reaches the shared session when FloorControl is on
+type floor struct {
+	// passing is held while checking who is driving so watchFloor and watchMob take turns
+	passing      sync.Mutex
+	mu           sync.Mutex
+	guestDriving bool
+	// held is the guest's input that may be the start of driveKey while they are not driving
+	held         []byte
+	lastRejected time.Time
+	// driver is the name of whoever is driving
+	driver string
+	// mobDriver is whose turn it is when Mob is on
+	mobDriver string
+	// statusRight is the shared session's own status-right before pair changed it
+	statusRight string
+}
+
+// startFloor lets the host and guest pass the keyboard with driveKey, the host drives first
+func (hs *HostSession) startFloor() error {
+	srv := hs.sharedTmux()
+	prefixes, err := srv.Prefixes()
+	if err != nil {
+		return err
+	}
+	hs.floor = &floor{}
+	bindings := map[string][]string{driveKey: {"switch-client", "-r"}}
+	if hs.Mob.Turn > 0 {
+		for key, cmd := range mobBindings() {
+			bindings[key] = cmd
+		}
+	}
+	// only driveKey gets through from a guest who is not driving, so they can ask for the keyboard
+	b, _ := tmux.KeyBytes(driveKey)
+	for _, prefix := range prefixes {
+		hs.floorKeys = append(hs.floorKeys, append(append([]byte{}, prefix...), b...))
+	}
+	for key, cmd := range bindings {
+		for _, s := range hs.floorServers() {
+			if err := hs.bindKey(s, key, cmd...); err != nil {
+				return err
+			}
+		}
+	}
+	status, err := srv.Run("show-options", "-qv", "-t", hs.TmuxSession, "status-right")
+	if err != nil {
+		return fmt.Errorf("could not get status line: %w", err)
+	}
+	hs.floor.statusRight = strings.TrimSuffix(string(status), "\n")
+	if err := hs.showDriverStatus(hs.TmuxSession); err != nil {
+		return err
+	}
+	hs.setDriver(false)
+	return nil
+}
+
+// stopFloor gives the keyboard back to the host and puts tmux back as it was
+func (hs *HostSession) stopFloor() {
+	if hs.floor == nil {
+		return
+	}
+	hs.setDriver(false)
+	srv := hs.sharedTmux()
+	if hs.Mob.Turn > 0 {
+		for _, s := range hs.floorServers() {
+			_, _ = s.Run("set-option", "-gu", "@pair-mob-order")
+		}
+	}
+	_, _ = srv.Run("set-option", "-gu", "@pair-driver")
+	_, _ = srv.Run("set-option", "-gu", "@pair-mob")
+	_, _ = srv.Run("set-option", "-gu", "@pair-mob-until")
+	// an empty value means the session used the global status line
+	if hs.floor.statusRight == "" {
+		_, _ = srv.Run("set-option", "-u", "-t", hs.TmuxSession, "status-right")
+	} else {
+		_, _ = srv.Run("set-option", "-t", hs.TmuxSession, "status-right", hs.floor.statusRight)
+	}
+}
+
+// floorServers are the servers the host and guest press driveKey on
+func (hs *HostSession) floorServers() []tmux.Server {
+	if hs.IsolatedServer != nil {
+		return []tmux.Server{hs.Tmux, *hs.IsolatedServer}
+	}
+	return []tmux.Server{hs.Tmux}
+}
+
+// showDriverStatus puts who is driving in front of the status line of session
+func (hs *HostSession) showDriverStatus(session string) error {
+	srv := hs.sharedTmux()
+	b, err := srv.Run("show-options", "-qv", "-t", session, "status-right")
+	if err == nil && len(bytes.TrimSpace(b)) == 0 {
+		b, err = srv.Run("show-options", "-gqv", "status-right")
+	}
+	if err != nil {
+		return fmt.Errorf("could not get status line: %w", err)
+	}
+	value := strings.TrimSuffix(string(b), "\n")
+	if strings.HasPrefix(value, driverStatus) {
+		return nil
+	}
+	if _, err := srv.Run("set-option", "-t", session, "status-right", driverStatus+value); err != nil {
+		return fmt.Errorf("could not set status line: %w", err)
+	}
+	return nil
+}
+
+// setDriver gives the keyboard to the guest or the host, the host's tmux client is made
+// read-only while the guest drives
+func (hs *HostSession) setDriver(guest bool) {
+	hs.floor.mu.Lock()
+	hs.floor.guestDriving = guest
+	hs.floor.held = nil
+	name := hs.floor.mobDriver
+	if name == "" || hs.isGuest(name) != guest {
+		name = hs.hostDisplayName()
+		if guest {
+			name = hs.guestDisplayName()
+		}
+	}
+	hs.floor.driver = name
+	hs.floor.mu.Unlock()
+	if _, err := hs.sharedTmux().Run("set-option", "-g", "@pair-driver", name); err != nil {
+		hs.Debug.Printf("could not show driver: %s", err)
+	}
+	if c, ok := hs.findClient(hs.Tmux, hs.HostState.Client); ok && c.ReadOnly != guest {
+		hs.toggleReadOnly(hs.Tmux, c)
+	}
+	hs.Debug.Printf("%s is driving", name)
+}
+
+func (hs *HostSession) driverName() string {
+	hs.floor.mu.Lock()
+	defer hs.floor.mu.Unlock()
+	return hs.floor.driver
+}
+
+func (hs *HostSession) hostDisplayName() string {
+	if hs.Name != "" {
+		return hs.Name
+	}
+	return "host"
+}
+
+// guestIdentity is the best name for the guest in the history, with their email if they gave it
+func (hs *HostSession) guestIdentity() string {
+	if hs.guestCoauthor != "" && hs.guestName == "" {
+		return hs.guestCoauthor
+	}
+	return hs.guestDisplayName()
+}
+
+func (hs *HostSession) guestDisplayName() string {
+	if hs.guestName != "" {
+		return hs.guestName
+	}
+	return "guest"
+}
+
+// findClient returns the tmux client called name on srv
+func (hs *HostSession) findClient(srv tmux.Server, name string) (tmux.Client, bool) {
+	clients, err := srv.Clients("")
+	if err != nil {
+		hs.Debug.Printf("could not check clients: %s", err)
+		return tmux.Client{}, false
+	}
+	for _, c := range clients {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return tmux.Client{}, false
+}
+
+// toggleReadOnly flips the read-only flag of client, keeping it in its session as switch-client
+// would otherwise move it
+func (hs *HostSession) toggleReadOnly(srv tmux.Server, client tmux.Client) {
+	if _, err := srv.Run("switch-client", "-c", client.Name, "-t", "="+client.Session, "-r"); err != nil {
+		hs.Debug.Printf("could not change keyboard of %s: %s", client.Name, err)
+	}
+}
+
+// watchFloor passes the keyboard when the host or guest press driveKey, which shows up as
+// their tmux client turning read-only or back. The switch-client of driveKey runs the
+// client-session-changed hook, which wakes up checkFloor.
+func (hs *HostSession) watchFloor(done <-chan struct{}) {
+	channel := fmt.Sprintf("pair-floor-%d", os.Getpid())
+	var wg sync.WaitGroup
+	for _, srv := range hs.floorServers() {
+		wg.Add(1)
+		go func(srv tmux.Server) {
+			defer wg.Done()
+			err := hs.watchHook(srv, "client-session-changed", channel, done, func() error {
+				hs.checkFloor()
+				return nil
+			})
+			if err != nil {
+				hs.Debug.Printf("not watching for %s: %s", driveKey, err)
+			}
+		}(srv)
+	}
+	wg.Wait()
+	<-done
+	hs.setDriver(false)
+}
+
+func (hs *HostSession) checkFloor() {
+	hs.floor.passing.Lock()
+	defer hs.floor.passing.Unlock()
+	hs.floor.mu.Lock()
+	guestDriving := hs.floor.guestDriving
+	hs.floor.mu.Unlock()
+	name, _ := hs.guestClient()
+	if c, ok := hs.findClient(hs.sharedTmux(), name); ok && c.ReadOnly {
+		// the guest's client is never read-only, their input is held back by pair instead
+		hs.toggleReadOnly(hs.sharedTmux(), c)
+		if guestDriving {
+			hs.setDriver(false)
+			hs.tellHost("pair: you are driving")
+			hs.tellGuest(fmt.Sprintf("pair: %s is driving", hs.driverName()))
+			return
+		}
+		hs.tellHost(fmt.Sprintf("pair: %s wants to drive, press prefix %s to hand over", hs.guestDisplayName(), driveKey))
+		hs.tellGuest("pair: asked the host to let you drive")
+		return
+	}
+	host, ok := hs.findClient(hs.Tmux, hs.HostState.Client)
+	if ok && host.ReadOnly != guestDriving {
+		hs.setDriver(host.ReadOnly)
+		if host.ReadOnly {
+			hs.tellHost(fmt.Sprintf("pair: %s is driving, press prefix %s to take over", hs.guestDisplayName(), driveKey))
+			hs.tellGuest("pair: you are driving")
+		} else {
+			hs.tellGuest(fmt.Sprintf("pair: %s is driving, press prefix %s to ask for the keyboard", hs.driverName(), driveKey))
+		}
+	}
+}
+
+// gateGuestInput drops the guest's input unless they are driving, apart from floorKeys so they
+// can ask for the keyboard
+func (hs *HostSession) gateGuestInput(input []byte) []byte {
+	if hs.floor == nil {
+		return input
+	}
+	f := hs.floor
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.guestDriving {
+		return input
+	}
+	var out []byte
+	dropped := false
+	buf := append(f.held, input...)
+	f.held = nil
+scan:
+	for i := 0; i < len(buf); {
+		for _, seq := range hs.floorKeys {
+			if bytes.HasPrefix(buf[i:], seq) {
+				out = append(out, seq...)
+				i += len(seq)
+				continue scan
+			}
+			if bytes.HasPrefix(seq, buf[i:]) {
+				f.held = append([]byte{}, buf[i:]...)
+				break scan
+			}
+		}
+		dropped = true
+		i++
+	}
+	if dropped && time.Since(f.lastRejected) > time.Second {
+		f.lastRejected = time.Now()
+		go hs.rejectInput(fmt.Sprintf("%s is driving, press prefix %s to ask for the keyboard", f.driver, driveKey))
+	}
+	return out
+}
@@ -0,0 +1,27 @@
+package session
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGateGuestInput(t *testing.T) {
+	hs := &HostSession{
+		floorKeys: [][]byte{[]byte("\x02D")},
+		// don't send rejections, there is no data channel
+		floor: &floor{lastRejected: time.Now().Add(time.Hour)},
+	}
+	if out := hs.gateGuestInput([]byte("ls\r")); len(out) != 0 {
+		t.Errorf("expected input to be dropped, got %q", out)
+	}
+	if out := hs.gateGuestInput([]byte("x\x02")); len(out) != 0 {
+		t.Errorf("expected prefix to be held, got %q", out)
+	}
+	if out := hs.gateGuestInput([]byte("Dy")); string(out) != "\x02D" {
+		t.Errorf("expected drive key to be sent, got %q", out)
+	}
+	hs.floor.guestDriving = true
+	if out := hs.gateGuestInput([]byte("ls\r")); string(out) != "ls\r" {
+		t.Errorf("expected driver's input to be sent, got %q", out)
+	}
+}
@@ -0,0 +1,164 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bottlerocketlabs/pair/pkg/env"
+)
+
+// Hello is sent by the guest as soon as the data channel opens to describe their terminal,
+// the host uses it to start the shared pty with an environment the guest can render
+type Hello struct {
+	Term      string            `json:"term,omitempty"`
+	ColorTerm string            `json:"colorterm,omitempty"`
+	Locale    map[string]string `json:"locale,omitempty"`
+	// Terminfo is the guest's terminfo entry for Term, in the source format printed by infocmp -x
+	Terminfo string `json:"terminfo,omitempty"`
+	// Name and Email credit the guest in commits made while pairing
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// termName is what a terminal name can contain, anything else could be taken as an option by
+// infocmp or tic, or escape TERMINFO
+var termName = regexp.MustCompile(`^[A-Za-z0-9._+-]+$`)
+
+// NewHello describes the terminal of the environment
+func NewHello(environ []string) Hello {
+	e := env.Map(environ)
+	h := Hello{
+		Term:      e["TERM"],
+		ColorTerm: e["COLORTERM"],
+		Locale:    map[string]string{},
+	}
+	for k, v := range e {
+		if k == "LANG" || k == "LANGUAGE" || strings.HasPrefix(k, "LC_") {
+			h.Locale[k] = v
+		}
+	}
+	if h.Term != "" {
+		if b, err := exec.Command("infocmp", "-x", "--", h.Term).Output(); err == nil {
+			h.Terminfo = string(b)
+		}
+	}
+	return h
+}
+
+func (h Hello) Encode() (string, error) {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return "", fmt.Errorf("could not marshal hello: %w", err)
+	}
+	msg, err := json.Marshal([]string{"hello", string(b)})
+	if err != nil {
+		return "", fmt.Errorf("could not marshal hello message: %w", err)
+	}
+	return string(msg), nil
+}
+
+func (h *Hello) Decode(payload string) error {
+	if err := json.Unmarshal([]byte(payload), h); err != nil {
+		return fmt.Errorf("could not unmarshal hello: %w", err)
+	}
+	if h.Term != "" && !termName.MatchString(h.Term) {
+		return fmt.Errorf("invalid terminal name in hello: %q", h.Term)
+	}
+	return nil
+}
+
+// TrueColor reports whether the guest's terminal advertises 24 bit colour support
+func (h Hello) TrueColor() bool {
+	return h.ColorTerm == "truecolor" || h.ColorTerm == "24bit"
+}
+
+// UTF8 reports whether the guest's locale uses UTF-8, following the precedence of setlocale
+func (h Hello) UTF8() bool {
+	locale := env.FirstNonBlank(h.Locale["LC_ALL"], h.Locale["LC_CTYPE"], h.Locale["LANG"])
+	locale = strings.ToUpper(locale)
+	return strings.Contains(locale, "UTF-8") || strings.Contains(locale, "UTF8")
+}
+
+// Coauthor is the guest as a git identity, such as "Alice <alice@example.com>", it is false
+// when the guest did not give a usable email address
+func (h Hello) Coauthor() (string, bool) {
+	email := strings.TrimSpace(h.Email)
+	if !strings.Contains(email, "@") || strings.ContainsAny(email, "<> \t\r\n") {
+		return "", false
+	}
+	name := strings.Join(strings.Fields(strings.Map(func(r rune) rune {
+		if r == '<' || r == '>' {
+			return -1
+		}
+		return r
+	}, h.Name)), " ")
+	if name == "" {
+		name = strings.SplitN(email, "@", 2)[0]
+	}
+	return fmt.Sprintf("%s <%s>", name, email), true
+}
+
+// Environ replaces the terminal and locale settings of environ with the guest's,
+// terminfoDir is used as TERMINFO when not empty
+func (h Hello) Environ(environ []string, terminfoDir string) []string {
+	if h.Term == "" {
+		return environ
+	}
+	var out []string
+	for _, kv := range environ {
+		k := strings.SplitN(kv, "=", 2)[0]
+		if k == "TERM" || k == "COLORTERM" || k == "TERMINFO" || k == "LANG" || k == "LANGUAGE" || strings.HasPrefix(k, "LC_") {
+			continue
+		}
+		out = append(out, kv)
+	}
+	out = append(out, "TERM="+h.Term)
+	if h.ColorTerm != "" {
+		out = append(out, "COLORTERM="+h.ColorTerm)
+	}
+	if terminfoDir != "" {
+		out = append(out, "TERMINFO="+terminfoDir)
+	}
+	var locale []string
+	for k, v := range h.Locale {
+		locale = append(locale, k+"="+v)
+	}
+	sort.Strings(locale)
+	return append(out, locale...)
+}
+
+// CompileTerminfo installs the guest's terminfo entry into a new directory if the host does not
+// already know their terminal, it returns an empty path if there was no need
+func (h Hello) CompileTerminfo() (string, error) {
+	if h.Term == "" || h.Terminfo == "" {
+		return "", nil
+	}
+	if !termName.MatchString(h.Term) {
+		return "", fmt.Errorf("invalid terminal name: %q", h.Term)
+	}
+	if err := exec.Command("infocmp", "--", h.Term).Run(); err == nil {
+		return "", nil
+	}
+	dir, err := ioutil.TempDir("", "pair-terminfo-")
+	if err != nil {
+		return "", fmt.Errorf("could not create terminfo directory: %w", err)
+	}
+	src := filepath.Join(dir, "terminfo.src")
+	if err := ioutil.WriteFile(src, []byte(h.Terminfo), 0600); err != nil {
+		return dir, fmt.Errorf("could not write terminfo source: %w", err)
+	}
+	cmd := exec.Command("tic", "-x", "-o", dir, "--", src)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return dir, fmt.Errorf("could not compile terminfo for %s: [%s] %w", h.Term, strings.TrimSpace(stderr.String()), err)
+	}
+	return dir, nil
+}
@@ -0,0 +1,87 @@
+package session
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHelloEnviron(t *testing.T) {
+	hello := Hello{
+		Term:      "xterm-kitty",
+		ColorTerm: "truecolor",
+		Locale:    map[string]string{"LANG": "de_DE.UTF-8", "LC_TIME": "C"},
+	}
+	var decoded Hello
+	msg, err := hello.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var payload []string
+	if err := json.Unmarshal([]byte(msg), &payload); err != nil || payload[0] != "hello" {
+		t.Fatalf("unexpected message: %s %v", msg, err)
+	}
+	if err := decoded.Decode(payload[1]); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	host := []string{"HOME=/home/host", "TERM=screen-256color", "LC_ALL=en_GB.UTF-8", "COLORTERM=24bit"}
+	expected := []string{"HOME=/home/host", "TERM=xterm-kitty", "COLORTERM=truecolor", "TERMINFO=/tmp/ti", "LANG=de_DE.UTF-8", "LC_TIME=C"}
+	if environ := decoded.Environ(host, "/tmp/ti"); !cmp.Equal(environ, expected) {
+		t.Errorf("got %v, expected %v", environ, expected)
+	}
+	if !decoded.UTF8() || !decoded.TrueColor() {
+		t.Errorf("expected utf-8 and true colour: %+v", decoded)
+	}
+	if environ := (Hello{}).Environ(host, ""); !cmp.Equal(environ, host) {
+		t.Errorf("environment should be unchanged without a hello: %v", environ)
+	}
+}
+
+func TestHelloCoauthor(t *testing.T) {
+	tests := []struct {
+		hello    Hello
+		expected string
+		ok       bool
+	}{
+		{Hello{Name: "Alice Guest", Email: "alice@example.com"}, "Alice Guest <alice@example.com>", true},
+		{Hello{Name: " Alice <x>\nGuest ", Email: "alice@example.com"}, "Alice x Guest <alice@example.com>", true},
+		{Hello{Email: "alice@example.com"}, "alice <alice@example.com>", true},
+		{Hello{Name: "Alice"}, "", false},
+		{Hello{Name: "Alice", Email: "alice@example.com>\nCo-authored-by: x <y@z>"}, "", false},
+	}
+	for _, test := range tests {
+		coauthor, ok := test.hello.Coauthor()
+		if coauthor != test.expected || ok != test.ok {
+			t.Errorf("%+v: got %q %t, expected %q %t", test.hello, coauthor, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestHelloDecodeTerm(t *testing.T) {
+	tests := []struct {
+		term string
+		ok   bool
+	}{
+		{"xterm-256color", true},
+		{"rxvt-unicode+iso", true},
+		{"vt220.x_y", true},
+		{"", true},
+		{"-oxterm", true},
+		{"../../etc/x", false},
+		{"xterm color", false},
+	}
+	for _, test := range tests {
+		payload, err := json.Marshal(Hello{Term: test.term})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded Hello
+		if err := decoded.Decode(string(payload)); (err == nil) != test.ok {
+			t.Errorf("%q: got %v, expected ok %t", test.term, err, test.ok)
+		}
+	}
+	if _, err := (Hello{Term: "x/y", Terminfo: "x|x,"}).CompileTerminfo(); err == nil {
+		t.Errorf("expected an invalid terminal name not to be compiled")
+	}
+}
@@ -0,0 +1,63 @@
+package session
+
+import (
+	"time"
+
+	"github.com/bottlerocketlabs/pair/pkg/history"
+	"github.com/pion/webrtc/v2"
+)
+
+// recordHistory appends a summary of the connection that just ended to History, if the
+// guest connected at all
+func (s *Session) recordHistory(role string, participants []string, reason string) {
+	if s.History == "" || s.connected.IsZero() {
+		return
+	}
+	e := s.connectionStats()
+	e.Start, e.End = s.connected, time.Now()
+	e.Role, e.Participants, e.ExitReason = role, participants, reason
+	if err := history.Append(s.History, e); err != nil {
+		s.Debug.Printf("could not record session: %s", err)
+	}
+	s.connected = time.Time{}
+}
+
+// connectionStats totals what went over the data channel and finds the types of candidates
+// the connection used
+func (s *Session) connectionStats() history.Entry {
+	var e history.Entry
+	if s.PeerConnection == nil {
+		return e
+	}
+	report := s.PeerConnection.GetStats()
+	var pair *webrtc.ICECandidatePairStats
+	for _, stats := range report {
+		switch stats := stats.(type) {
+		case webrtc.DataChannelStats:
+			e.BytesSent += stats.BytesSent
+			e.BytesReceived += stats.BytesReceived
+		case webrtc.ICECandidatePairStats:
+			if stats.Nominated || pair == nil && stats.State == webrtc.StatsICECandidatePairStateSucceeded {
+				pair = &stats
+			}
+		}
+	}
+	if pair == nil {
+		return e
+	}
+	if c, ok := report[pair.LocalCandidateID].(webrtc.ICECandidateStats); ok {
+		e.LocalCandidate = c.CandidateType.String()
+	}
+	if c, ok := report[pair.RemoteCandidateID].(webrtc.ICECandidateStats); ok {
+		e.RemoteCandidate = c.CandidateType.String()
+	}
+	return e
+}
+
+// exitReason describes how a session ended with err, ended is used when it ended normally
+func exitReason(err error, ended string) string {
+	if err != nil {
+		return err.Error()
+	}
+	return ended
+}
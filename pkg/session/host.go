@@ -1,37 +1,226 @@
 package session
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/atotto/clipboard"
+	"github.com/bottlerocketlabs/pair/pkg/audit"
 	"github.com/bottlerocketlabs/pair/pkg/handlers"
+	"github.com/bottlerocketlabs/pair/pkg/history"
+	"github.com/bottlerocketlabs/pair/pkg/sshkeys"
 	"github.com/bottlerocketlabs/pair/pkg/tmux"
 	"github.com/kr/pty"
 	"github.com/pion/webrtc/v2"
+	"golang.org/x/crypto/ssh"
 )
 
 type HostSession struct {
 	Session
+	// Tmux is the server the host's client is attached to
+	Tmux        tmux.Server
 	TmuxSession string
 	TmuxClient  string
-	Cmd         []string
-	Pty         *os.File
-	PtyReady    bool
+	// HostState is where the host's tmux client was before hosting, it is restored on exit
+	HostState tmux.ClientState
+	// KillSessionOnExit removes TmuxSession on exit, only set this when pair created it
+	KillSessionOnExit bool
+	// IsolatedServer is the dedicated tmux server TmuxSession lives on, it is killed on exit
+	IsolatedServer *tmux.Server
+	// Grouped gives the guest their own session in a group with TmuxSession,
+	// so they share its windows but can look at a different one to the host
+	Grouped bool
+	// Follow starts a grouped guest following the host's window, they can toggle it with prefix F
+	Follow bool
+	// Persistent keeps hosting after a guest leaves, inviting the next guest into the same session
+	Persistent bool
+	// InviteTTL is how long an invite can be used for, the server's default when zero
+	InviteTTL time.Duration
+	// MaxJoins is how many guests can join with an invite, one after another
+	MaxJoins int
+	// ShortCode gives the invite a code that can be read aloud, such as 7-crossover-clockwork
+	ShortCode bool
+	// Name identifies the host to guests, such as user@hostname
+	Name string
+	// AuthorizedKeys only lets in guests who sign a challenge with one of the keys,
+	// anyone with the invite can join when it is nil
+	AuthorizedKeys sshkeys.AuthorizedKeys
+	// Room is the name of a room on the sdp server guests can always join the host through
+	Room string
+	// RoomPassword restricts the room to guests who know it, nil leaves it as it is and empty removes it
+	RoomPassword *string
+	// RoomOwners are the key ids of teammates who can also host in the room, nil leaves them as they are
+	RoomOwners []string
+	// SignInvites signs each offer with SigningKey from the host's ssh-agent, or the first key in it
+	SignInvites bool
+	SigningKey  ssh.PublicKey
+	// AuditLog records everything the guest types and who they are
+	AuditLog *audit.Log
+	// AuditRedact leaves what the guest types while echo is off, such as passwords, out of the AuditLog
+	AuditRedact bool
+	// InputPolicy blocks key sequences from the guest or asks the host to allow them
+	InputPolicy InputPolicy
+	// FloorControl only lets the driver type, the host and guest pass the keyboard with prefix D
+	FloorControl bool
+	// Mob rotates the driver every Mob.Turn when it is set, it implies FloorControl
+	Mob Mob
+	// Coauthors adds Co-authored-by trailers for the guest to commits made in the shared session
+	Coauthors bool
+	// SizePolicy decides the size of the shared terminal when the host and guest sizes differ
+	SizePolicy SizePolicy
+	// Scrollback is the number of lines of history sent with the screen when the guest joins,
+	// a negative number sends nothing
+	Scrollback int
+	Cmd        []string
+	Pty        *os.File
+	PtyReady   bool
+
+	tmuxMu        sync.Mutex
+	control       *tmux.Control
+	savedBindings []savedBinding
+	// hooksMu guards hooks, the hooks added by watchHook, which are not added once removed
+	hooksMu        sync.Mutex
+	hooks          []installedHook
+	hooksRemoved   bool
+	sessionID      string
+	hostWindow     string
+	guestSession   string
+	guestSessionID string
+	guestWindow    string
+	// guestTTY is the tty of the active pane in guestTTYTarget, the window the guest sees,
+	// it is forgotten when tmux reports the active pane may have changed, which paneChanges counts
+	guestTTY       string
+	guestTTYTarget string
+	paneChanges    int
+
+	helloChan  chan Hello
+	authChan   chan authSignature
+	authorized chan struct{}
+	guestName  string
+	// guestCoauthor is who the guest said they are in their hello
+	guestCoauthor string
+	terminfoDir   string
+	// guestTermOption is the terminal feature added for the guest's terminal, such as terminal-features[2]
+	guestTermOption string
+	guestCmd        *exec.Cmd
+	// guestClientName is the tmux client guestCmd attached as, found by guestClient
+	guestClientName string
+	guestDone       chan struct{}
+
+	invite            string
+	inviteCode        string
+	inviteCodeExpires time.Time
+	roomInvite        string
+	inviteExpires     time.Time
+	joinsLeft         int
+
+	// inputMu guards the input rules and filter, and keeps guest input in order as it is written
+	inputMu       sync.Mutex
+	inputRules    []inputRule
+	inputFilter   *inputFilter
+	confirmations int
+	floor         *floor
+	hooksDir      string
+	// floorKeys are the sequences a guest can send when they are not driving
+	floorKeys [][]byte
+
+	sizeMu    sync.Mutex
+	guestSize pty.Winsize
+	size      Size
 }
 
-func (hs *HostSession) Run() error {
+func (hs *HostSession) Run() (err error) {
+	defer func() {
+		if rerr := hs.restoreTmux(); rerr != nil && err == nil {
+			err = fmt.Errorf("could not restore tmux: %w", rerr)
+		}
+	}()
+	hs.removeStaleHooks(hs.Tmux)
+	if len(hs.InputPolicy.Block) > 0 || len(hs.InputPolicy.Confirm) > 0 {
+		if err := hs.loadInputRules(); err != nil {
+			return err
+		}
+		prefixDone := make(chan struct{})
+		defer close(prefixDone)
+		go hs.watchPrefixes(prefixDone)
+	}
+	if err := hs.watchTmux(); err != nil {
+		hs.Debug.Printf("not watching tmux for changes: %s", err)
+	} else {
+		defer hs.control.Close()
+	}
+	if hs.Coauthors {
+		if err := hs.startCoauthors(); err != nil {
+			hs.Debug.Printf("not crediting guests in commits: %s", err)
+		}
+		defer hs.stopCoauthors()
+	}
+	if hs.FloorControl || hs.Mob.Turn > 0 {
+		if err := hs.startFloor(); err != nil {
+			return fmt.Errorf("could not start floor control: %w", err)
+		}
+		defer hs.stopFloor()
+	}
+	if hs.Room != "" {
+		defer func() {
+			if err := hs.leaveRoom(); err != nil {
+				hs.Debug.Printf("%s", err)
+			}
+		}()
+	}
+	interrupts := make(chan os.Signal, 1)
+	// hangups and terminations are interrupts too, so tmux is put back as it was
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(interrupts)
+	for {
+		err := hs.serveGuest(interrupts)
+		hs.recordHistory(history.RoleHost, []string{hs.hostDisplayName(), hs.guestIdentity()}, exitReason(err, "guest left"))
+		hs.endGuest()
+		if errors.Is(err, errInterrupted) {
+			if hs.Persistent {
+				return nil
+			}
+			return err
+		}
+		if !hs.Persistent && !hs.inviteUsable() {
+			return err
+		}
+		if err != nil {
+			_, _ = fmt.Fprintf(hs.Stderr, "\nGuest session ended: %s\n\n", err)
+			time.Sleep(time.Second)
+		} else {
+			_, _ = fmt.Fprintf(hs.Stderr, "\nGuest left, inviting the next one\n\n")
+		}
+	}
+}
+
+// serveGuest invites a guest and shares the session with them until they leave
+func (hs *HostSession) serveGuest(interrupts <-chan os.Signal) error {
 	err := hs.init()
 	if err != nil {
 		return fmt.Errorf("could not init host session: %w", err)
 	}
+	hs.guestDone = make(chan struct{})
+	finish := hs.finisher()
+	go func(done <-chan struct{}) {
+		select {
+		case <-interrupts:
+			hs.Debug.Printf("recieved interrupt\n")
+			finish(errInterrupted)
+		case <-done:
+		}
+	}(hs.guestDone)
+	hs.PeerConnection.OnICEConnectionStateChange(hs.iceConnectionStateChange())
 	hs.Debug.Printf("setting up connection")
 	if err := hs.createOffer(); err != nil {
 		return fmt.Errorf("could not create offer: %w", err)
@@ -42,31 +231,57 @@ func (hs *HostSession) Run() error {
 	if err != nil {
 		return fmt.Errorf("could not encode offer: %w", err)
 	}
-	verbose := ""
-	if hs.Verbose {
-		verbose = "-v"
+	policy := handlers.InvitePolicy{ExpiresIn: time.Until(hs.inviteExpires), MaxJoins: hs.MaxJoins}
+	if hs.ShortCode && (hs.inviteCode == "" || time.Now().After(hs.inviteCodeExpires)) {
+		if err := hs.renewCode(); err != nil {
+			return err
+		}
+	}
+	if hs.Room != "" && hs.roomInvite != hs.invite {
+		if err := hs.hostRoom(policy); err != nil {
+			return err
+		}
+		hs.roomInvite = hs.invite
 	}
-	_, err = fmt.Fprintf(hs.Stderr, "Share this command with your guest:\n\n  pair %s %s\n\n", verbose, hs.OfferSD.SDPURI)
+	command := hs.inviteCommand()
+	_, err = fmt.Fprintf(hs.Stderr, "Share this command with your guest:\n\n  %s\n\n", command)
 	if err != nil {
 		return fmt.Errorf("could not write sdp uri to stderr: %w", err)
 	}
-	err = clipboard.WriteAll(fmt.Sprintf("pair %s %s", verbose, hs.OfferSD.SDPURI))
+	_, _ = fmt.Fprintf(hs.Stderr, "Guests joining you for the first time are asked to check your key:\n\n  %s\n\n", sdpFingerprint(hs.OfferSD.SDP))
+	err = clipboard.WriteAll(command)
 	if err != nil {
 		_, _ = fmt.Fprintf(hs.Stderr, "Failed to write command to clipboard: %s\n\n", err)
 	} else {
 		_, _ = fmt.Fprintf(hs.Stderr, "It has been added to your clipboard automatically\n\n")
 	}
-	_, _ = fmt.Fprint(hs.Stderr, "Please press return key within 20 seconds of your pair starting their session\n")
-	_, _ = bufio.NewReader(hs.Stdin).ReadBytes('\n')
 	hs.Debug.Printf("uploading offer")
-	if err := hs.putSDP(hs.OfferSD.SDPURI, bytes.NewBuffer([]byte(offer))); err != nil {
+	if err := hs.putSDP(hs.OfferSD.SDPURI, bytes.NewBuffer([]byte(offer)), policy); err != nil {
+		if errors.Is(err, errInviteGone) {
+			// the next guest gets a new invite
+			hs.invite = ""
+		}
 		return fmt.Errorf("could not upload SDP offer: %w", err)
 	}
+	valid := time.Until(hs.inviteExpires).Round(time.Second)
+	if hs.joinsLeft > 1 {
+		_, _ = fmt.Fprintf(hs.Stderr, "Waiting for your guest to join, the invite can be used by %d more guests in the next %s\n", hs.joinsLeft, valid)
+	} else {
+		_, _ = fmt.Fprintf(hs.Stderr, "Waiting for your guest to join, the invite is valid for %s\n", valid)
+	}
 	hs.Debug.Printf("waiting for response")
-	answer, err := hs.getSDP(hs.OfferSD.SDPAnswerURI)
+	answer, err := hs.waitForAnswer()
 	if err != nil {
 		return fmt.Errorf("could not get SDP answer: %w", err)
 	}
+	hs.joinsLeft--
+	if hs.Room != "" && hs.joinsLeft == 0 {
+		// guests joining the room now are told nobody is hosting rather than the invite is used
+		if err := hs.leaveRoom(); err != nil {
+			hs.Debug.Printf("%s", err)
+		}
+		hs.roomInvite = ""
+	}
 	hs.Debug.Printf("got response")
 	var answerSD SessionDescription
 	err = answerSD.Decode(string(answer))
@@ -82,6 +297,49 @@ func (hs *HostSession) Run() error {
 	return nil
 }
 
+// errInterrupted stops hosting when the host presses ctrl-c
+var errInterrupted = errors.New("interrupted")
+
+// endGuest disconnects the guest and tidies up after them, leaving the shared session as it was
+func (hs *HostSession) endGuest() {
+	if hs.guestDone != nil {
+		close(hs.guestDone)
+		hs.guestDone = nil
+	}
+	hs.PtyReady = false
+	hs.setCoauthors(nil)
+	hs.guestCoauthor = ""
+	if hs.Pty != nil {
+		_ = hs.Pty.Close()
+	}
+	if hs.guestCmd != nil {
+		_ = hs.guestCmd.Process.Kill()
+		_ = hs.guestCmd.Wait()
+		hs.tmuxMu.Lock()
+		hs.guestCmd, hs.guestClientName = nil, ""
+		hs.tmuxMu.Unlock()
+	}
+	if hs.PeerConnection != nil {
+		if err := hs.PeerConnection.Close(); err != nil {
+			hs.Debug.Printf("could not close peer connection: %s", err)
+		}
+	}
+	if err := hs.stopGuestSession(); err != nil {
+		hs.Debug.Printf("could not remove guest session: %s", err)
+	}
+	hs.unconfigureGuestTerminal()
+	hs.sizeMu.Lock()
+	sized := !hs.size.IsZero()
+	hs.guestSize, hs.size = pty.Winsize{}, Size{}
+	hs.sizeMu.Unlock()
+	if sized {
+		hs.tmuxMu.Lock()
+		session := hs.TmuxSession
+		hs.tmuxMu.Unlock()
+		hs.unsizeTmuxWindows(session)
+	}
+}
+
 func (hs *HostSession) setHostRemoteDescriptionAndWait() error {
 	answer := webrtc.SessionDescription{
 		Type: webrtc.SDPTypeAnswer,
@@ -91,7 +349,7 @@ func (hs *HostSession) setHostRemoteDescriptionAndWait() error {
 		return fmt.Errorf("could not set remote description: %w", err)
 	}
 	if hs.TmuxClient != "" {
-		err := tmux.MoveClientToSession(hs.TmuxClient, hs.TmuxSession)
+		err := hs.Tmux.SwitchClient(hs.TmuxClient, hs.TmuxSession)
 		if err != nil {
 			return fmt.Errorf("cannot move client: %w", err)
 		}
@@ -108,38 +366,218 @@ func (hs *HostSession) setHostRemoteDescriptionAndWait() error {
 	return nil
 }
 
+// waitForAnswer waits for the guest's answer until the invite expires, or returns early if the session is stopped
+func (hs *HostSession) waitForAnswer() ([]byte, error) {
+	type result struct {
+		answer []byte
+		err    error
+	}
+	fetched := make(chan result, 1)
+	go func() {
+		answer, err := hs.pollSDP(hs.OfferSD.SDPAnswerURI, hs.inviteExpires)
+		fetched <- result{answer, err}
+	}()
+	// a short code can expire before the invite, the guest is given a new one to join with
+	codeTimer := time.NewTimer(time.Until(hs.inviteCodeExpires))
+	defer codeTimer.Stop()
+	var codeExpired <-chan time.Time
+	if hs.inviteCode != "" && hs.Room == "" {
+		codeExpired = codeTimer.C
+	}
+	for {
+		select {
+		case r := <-fetched:
+			return r.answer, r.err
+		case <-codeExpired:
+			codeExpired = nil
+			if !time.Now().Before(hs.inviteExpires) {
+				continue
+			}
+			if err := hs.renewCode(); err != nil {
+				hs.Debug.Printf("%s", err)
+				_, _ = fmt.Fprintf(hs.Stderr, "Your short code expired and a new one could not be registered, share this url instead:\n\n  %s\n\n", hs.OfferSD.SDPURI)
+				continue
+			}
+			command := hs.inviteCommand()
+			_, _ = fmt.Fprintf(hs.Stderr, "Your short code expired, share this command instead:\n\n  %s\n\n", command)
+			if err := clipboard.WriteAll(command); err != nil {
+				hs.Debug.Printf("could not write command to clipboard: %s", err)
+			}
+			codeTimer.Reset(time.Until(hs.inviteCodeExpires))
+			codeExpired = codeTimer.C
+		case err := <-hs.ErrorChan:
+			if err == nil {
+				err = fmt.Errorf("stopped before guest answered")
+			}
+			return nil, err
+		}
+	}
+}
+
+// renewCode gives the invite a new short code, codes last up to handlers.MaxCodeTTL so an
+// invite that lasts longer is given a new one when its code expires
+func (hs *HostSession) renewCode() error {
+	ttl := time.Until(hs.inviteExpires)
+	code, err := hs.registerCode(hs.invite, handlers.InvitePolicy{ExpiresIn: ttl, MaxJoins: hs.MaxJoins})
+	if err != nil {
+		return fmt.Errorf("could not get short code for invite: %w", err)
+	}
+	if ttl > handlers.MaxCodeTTL {
+		ttl = handlers.MaxCodeTTL
+	}
+	hs.inviteCode = code
+	hs.inviteCodeExpires = time.Now().Add(ttl)
+	return nil
+}
+
+// inviteCommand is the command a guest joins with
+func (hs *HostSession) inviteCommand() string {
+	command := "pair"
+	if hs.Verbose {
+		command += " -v"
+	}
+	if hs.Room != "" {
+		command += " " + handlers.RoomURL(hs.SDPServer, hs.Room)
+	} else if hs.inviteCode != "" {
+		// the code is resolved against the guest's sdp server, so it needs to be the same
+		if hs.SDPServer != DefaultSDPServer {
+			command += " -sdp " + hs.SDPServer
+		}
+		command += " " + hs.inviteCode
+	} else {
+		command += " " + hs.OfferSD.SDPURI
+	}
+	return command
+}
+
+func (hs *HostSession) iceConnectionStateChange() func(webrtc.ICEConnectionState) {
+	finish := hs.finisher()
+	return func(state webrtc.ICEConnectionState) {
+		hs.Debug.Printf("ice connection state: %s", state)
+		switch state {
+		case webrtc.ICEConnectionStateDisconnected, webrtc.ICEConnectionStateFailed, webrtc.ICEConnectionStateClosed:
+			finish(fmt.Errorf("guest connection %s", state))
+		}
+	}
+}
+
+// waitForHello gives the guest a moment to describe their terminal, older guests do not so
+// the host's own terminal settings are used for them
+func (hs *HostSession) waitForHello() Hello {
+	select {
+	case hello := <-hs.helloChan:
+		return hello
+	case <-time.After(2 * time.Second):
+		hs.Debug.Printf("guest did not describe their terminal")
+		return Hello{}
+	}
+}
+
+// configureGuestTerminal tells tmux about features of the guest's terminal that terminfo cannot,
+// tmux only has server wide options for this so unconfigureGuestTerminal removes it again
+func (hs *HostSession) configureGuestTerminal(hello Hello) {
+	if hello.Term == "" || !hello.TrueColor() {
+		return
+	}
+	srv := hs.sharedTmux()
+	option, feature := "terminal-overrides", hello.Term+":Tc"
+	if v, err := srv.Version(); err == nil && v.AtLeast(3, 2) {
+		option, feature = "terminal-features", hello.Term+":RGB"
+	}
+	added, err := srv.AddServerOption(option, feature)
+	if err != nil {
+		hs.Debug.Printf("could not enable true colour for guest: %s", err)
+		return
+	}
+	hs.guestTermOption = added
+}
+
+// unconfigureGuestTerminal removes what configureGuestTerminal added for the last guest
+func (hs *HostSession) unconfigureGuestTerminal() {
+	if hs.guestTermOption == "" {
+		return
+	}
+	if err := hs.sharedTmux().RemoveServerOption(hs.guestTermOption); err != nil {
+		hs.Debug.Printf("%s", err)
+	}
+	hs.guestTermOption = ""
+}
+
 func (hs *HostSession) dataChannelOnOpen() func() {
+	finish := hs.finisher()
 	return func() {
 		hs.Debug.Printf("session started")
-		cmd := exec.Command(hs.Cmd[0], hs.Cmd[1:]...)
+		hs.connected = time.Now()
+		if hs.AuthorizedKeys != nil {
+			name, err := hs.authorizeGuest()
+			if err != nil {
+				finish(err)
+				return
+			}
+			hs.guestName = name
+			close(hs.authorized)
+			_, _ = fmt.Fprintf(hs.Stderr, "%s joined\n", name)
+			hs.tellHost(fmt.Sprintf("pair: %s joined", name))
+		}
+		args := hs.Cmd
+		if hs.Grouped {
+			var err error
+			args, err = hs.startGuestSession()
+			if err != nil {
+				finish(fmt.Errorf("could not start guest session: %w", err))
+				return
+			}
+		}
+		hello := hs.waitForHello()
+		hs.Debug.Printf("guest terminal: %s %s %v", hello.Term, hello.ColorTerm, hello.Locale)
 		var err error
+		hs.terminfoDir, err = hello.CompileTerminfo()
+		if err != nil {
+			hs.Debug.Printf("using host terminfo: %s", err)
+		}
+		hs.configureGuestTerminal(hello)
+		if coauthor, ok := hello.Coauthor(); ok {
+			hs.Debug.Printf("crediting %s in commits", coauthor)
+			hs.setCoauthors([]string{coauthor})
+			hs.guestCoauthor = coauthor
+		}
+		if err := hs.sendSnapshot(); err != nil {
+			hs.Debug.Printf("could not send snapshot: %s", err)
+		}
+		if hello.UTF8() && len(args) > 0 && filepath.Base(args[0]) == "tmux" {
+			args = append([]string{args[0], "-u"}, args[1:]...)
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Env = hello.Environ(os.Environ(), hs.terminfoDir)
 		hs.Pty, err = pty.Start(cmd)
 		if err != nil {
-			hs.ErrorChan <- fmt.Errorf("could not start pty: %w", err)
+			finish(fmt.Errorf("could not start pty: %w", err))
 			return
 		}
+		hs.tmuxMu.Lock()
+		hs.guestCmd = cmd
+		hs.tmuxMu.Unlock()
 		hs.PtyReady = true
-		c := make(chan os.Signal, 1)
-		signal.Notify(c, os.Interrupt)
-		go func() {
-			for range c {
-				hs.Debug.Printf("recieved interrupt\n")
-				hs.ErrorChan <- fmt.Errorf("sigint")
-				return
-			}
-		}()
+		go hs.watchHostSize(hs.guestDone)
+		if hs.floor != nil {
+			go hs.watchFloor(hs.guestDone)
+		}
+		if hs.Mob.Turn > 0 {
+			go hs.watchMob(hs.guestDone)
+		}
 		buf := make([]byte, 1024)
 		for {
 			nr, err := hs.Pty.Read(buf)
 			if err != nil {
-				if err == io.EOF {
+				// linux reports the end of a pty as EIO
+				if err == io.EOF || errors.Is(err, syscall.EIO) {
 					err = nil
 				}
-				hs.ErrorChan <- err
+				finish(err)
 				return
 			}
 			if err = hs.DataChannel.Send(buf[0:nr]); err != nil {
-				hs.ErrorChan <- fmt.Errorf("could not send to data channel: %w", err)
+				finish(fmt.Errorf("could not send to data channel: %w", err))
 				return
 			}
 		}
@@ -169,7 +607,59 @@ func parseSizeMessage(msg []byte) ([]uint16, error) {
 }
 
 func (hs *HostSession) dataChannelOnMessage() func(msg webrtc.DataChannelMessage) {
+	finish := hs.finisher()
 	return func(p webrtc.DataChannelMessage) {
+		if p.IsString && bytes.HasPrefix(p.Data, []byte(`["hello",`)) {
+			var msg []string
+			if err := json.Unmarshal(p.Data, &msg); err != nil || len(msg) != 2 {
+				finish(fmt.Errorf("could not unmarshal json 'hello' message: %s", p.Data))
+				return
+			}
+			var hello Hello
+			if err := hello.Decode(msg[1]); err != nil {
+				// the guest still gets the host's terminal settings
+				hs.Debug.Printf("ignoring guest terminal: %s", err)
+				hello = Hello{}
+			}
+			select {
+			case hs.helloChan <- hello:
+			default:
+			}
+			return
+		}
+		if p.IsString && bytes.HasPrefix(p.Data, []byte(`["ping",`)) {
+			var msg []string
+			if err := json.Unmarshal(p.Data, &msg); err != nil || len(msg) != 2 {
+				hs.Debug.Printf("ignoring malformed ping: %s", p.Data)
+				return
+			}
+			pong, _ := json.Marshal([]string{"pong", msg[1]})
+			if err := hs.DataChannel.SendText(string(pong)); err != nil {
+				hs.Debug.Printf("could not send pong: %s", err)
+			}
+			return
+		}
+		if p.IsString && bytes.HasPrefix(p.Data, []byte(`["auth_response",`)) {
+			var msg []string
+			var sig authSignature
+			if err := json.Unmarshal(p.Data, &msg); err != nil || len(msg) != 2 || json.Unmarshal([]byte(msg[1]), &sig) != nil {
+				finish(fmt.Errorf("could not unmarshal json 'auth_response' message: %s", p.Data))
+				return
+			}
+			select {
+			case hs.authChan <- sig:
+			default:
+			}
+			return
+		}
+		if hs.AuthorizedKeys != nil {
+			select {
+			case <-hs.authorized:
+			default:
+				hs.Debug.Printf("ignoring message from guest before they are authorized")
+				return
+			}
+		}
 		// wait for pty to be ready
 		for hs.PtyReady != true {
 			time.Sleep(5 * time.Millisecond)
@@ -177,32 +667,39 @@ func (hs *HostSession) dataChannelOnMessage() func(msg webrtc.DataChannelMessage
 		if p.IsString {
 			if len(p.Data) > 2 && p.Data[0] == '[' && p.Data[1] == '"' {
 				var msg []string
-				err := json.Unmarshal(p.Data, &msg)
+				_ = json.Unmarshal(p.Data, &msg)
 				if len(msg) == 0 {
-					hs.ErrorChan <- fmt.Errorf("could not unmarshal json message: %w", err)
+					hs.Debug.Printf("ignoring malformed message: %s", p.Data)
 					return
 				}
 				if msg[0] == "stdin" {
+					if len(msg) != 2 {
+						hs.Debug.Printf("ignoring malformed stdin: %s", p.Data)
+						return
+					}
 					toWrite := []byte(msg[1])
 					if len(toWrite) == 0 {
 						// shrug
 						return
 					}
-					_, err := hs.Pty.Write(toWrite)
-					if err != nil {
-						hs.ErrorChan <- fmt.Errorf("could not write to pty: %w", err)
+					if err := hs.audit(audit.KindStdin, toWrite); err != nil {
+						finish(err)
+						return
+					}
+					if err := hs.writeGuestInput(toWrite); err != nil {
+						finish(fmt.Errorf("could not write to pty: %w", err))
 					}
 					return
 				}
 				if msg[0] == "set_size" {
 					size, err := parseSizeMessage(p.Data)
-					if err != nil {
-						hs.ErrorChan <- fmt.Errorf("could not unmarshal json 'set_size' message: %w", err)
+					if err != nil || len(size) < 3 {
+						hs.Debug.Printf("ignoring malformed set_size: %s", p.Data)
 						return
 					}
 					ws, err := pty.GetsizeFull(hs.Pty)
 					if err != nil {
-						hs.ErrorChan <- fmt.Errorf("could not get size of terminal: %w", err)
+						finish(fmt.Errorf("could not get size of terminal: %w", err))
 						return
 					}
 					ws.Rows = size[1]
@@ -212,22 +709,32 @@ func (hs *HostSession) dataChannelOnMessage() func(msg webrtc.DataChannelMessage
 						ws.X = size[3]
 						ws.Y = size[4]
 					}
-					hs.Debug.Printf("changing size of terminal %+v\n", ws)
-					if err := pty.Setsize(hs.Pty, ws); err != nil {
-						hs.ErrorChan <- fmt.Errorf("could not set terminal size: %w", err)
+					hs.sizeMu.Lock()
+					hs.guestSize = *ws
+					hs.sizeMu.Unlock()
+					if err := hs.applySize(); err != nil {
+						finish(fmt.Errorf("could not set terminal size: %w", err))
 					}
 					return
 				}
+				if msg[0] == "redraw" {
+					hs.redrawGuest()
+					return
+				}
 			}
 			if string(p.Data) == "quit" {
-				hs.ErrorChan <- nil
+				finish(nil)
 				return
 			}
-			hs.ErrorChan <- fmt.Errorf("unexpected string message: %s", string(p.Data))
+			// newer guests may send messages this host does not know about
+			hs.Debug.Printf("ignoring unknown message: %s", p.Data)
 		} else {
-			_, err := hs.Pty.Write(p.Data)
-			if err != nil {
-				hs.ErrorChan <- fmt.Errorf("could not write to pty: %w", err)
+			if err := hs.audit(audit.KindBinary, p.Data); err != nil {
+				finish(err)
+				return
+			}
+			if err := hs.writeGuestInput(p.Data); err != nil {
+				finish(fmt.Errorf("could not write to pty: %w", err))
 				return
 			}
 		}
@@ -236,7 +743,12 @@ func (hs *HostSession) dataChannelOnMessage() func(msg webrtc.DataChannelMessage
 
 func (hs *HostSession) dataChannelOnClose() func() {
 	return func() {
-		_ = hs.Pty.Close()
+		if hs.Pty != nil {
+			_ = hs.Pty.Close()
+		}
+		if hs.terminfoDir != "" {
+			_ = os.RemoveAll(hs.terminfoDir)
+		}
 		hs.Debug.Printf("data channel closed")
 	}
 }
@@ -249,6 +761,14 @@ func (hs *HostSession) dataChannelOnError() func(err error) {
 
 func (hs *HostSession) onDataChannel() func(*webrtc.DataChannel) {
 	return func(dc *webrtc.DataChannel) {
+		hs.helloChan = make(chan Hello, 1)
+		hs.authChan = make(chan authSignature, 1)
+		hs.authorized = make(chan struct{})
+		hs.inputMu.Lock()
+		if hs.inputRules != nil {
+			hs.inputFilter = &inputFilter{rules: hs.inputRules}
+		}
+		hs.inputMu.Unlock()
 		dc.OnOpen(hs.dataChannelOnOpen())
 		dc.OnMessage(hs.dataChannelOnMessage())
 		dc.OnClose(hs.dataChannelOnClose())
@@ -267,10 +787,40 @@ func (hs *HostSession) createOffer() error {
 	if err != nil {
 		return fmt.Errorf("could not set local peer connection description: %w", err)
 	}
+	if !hs.inviteUsable() {
+		hs.newInvite()
+	}
 	hs.OfferSD = SessionDescription{
 		SDP:          offer.SDP,
-		SDPURI:       handlers.GenSDPURL(hs.SDPServer),
-		SDPAnswerURI: handlers.GenSDPURL(hs.SDPServer),
+		SDPURI:       hs.invite,
+		SDPAnswerURI: handlers.GenMailboxURL(hs.SDPServer),
+		Host:         hs.Name,
+	}
+	if hs.SignInvites {
+		if err := hs.signOffer(); err != nil {
+			return fmt.Errorf("could not sign invite: %w", err)
+		}
 	}
 	return nil
 }
+
+// newInvite makes an invite for guests to join with, the same invite is offered to each guest
+// until it has been used MaxJoins times or expires
+func (hs *HostSession) newInvite() {
+	ttl := hs.InviteTTL
+	if ttl <= 0 {
+		ttl = handlers.MailboxTTL
+	}
+	hs.invite = handlers.GenMailboxURL(hs.SDPServer)
+	hs.inviteCode = ""
+	hs.inviteExpires = time.Now().Add(ttl)
+	hs.joinsLeft = hs.MaxJoins
+	if hs.joinsLeft <= 0 {
+		hs.joinsLeft = 1
+	}
+}
+
+// inviteUsable reports whether another guest can join with the current invite
+func (hs *HostSession) inviteUsable() bool {
+	return hs.invite != "" && hs.joinsLeft > 0 && time.Now().Before(hs.inviteExpires)
+}
@@ -1,8 +1,16 @@
 package session
 
 import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
 	"testing"
 
+	"github.com/bottlerocketlabs/pair/pkg/tmux"
+
 	"github.com/google/go-cmp/cmp"
 )
 
@@ -17,3 +25,62 @@ func TestUnmarshalSizeMessage(t *testing.T) {
 		t.Errorf("got %v, expected %v", recieved, expected)
 	}
 }
+
+func TestFinisherIgnoresEarlierConnection(t *testing.T) {
+	var s Session
+	s.ErrorChan = make(chan error, 1)
+	earlier := s.finisher()
+	s.ErrorChan = make(chan error, 1)
+	current := s.finisher()
+	earlier(fmt.Errorf("guest connection closed"))
+	select {
+	case err := <-s.ErrorChan:
+		t.Fatalf("earlier connection stopped the current one: %v", err)
+	default:
+	}
+	current(nil)
+	current(fmt.Errorf("does not block when already stopping"))
+	if err := <-s.ErrorChan; err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+// hookRunner answers show-hooks with hooks and records the hooks removed
+type hookRunner struct {
+	hooks   string
+	removed []string
+}
+
+func (r *hookRunner) Run(args []string) ([]byte, error) {
+	cmd := strings.Join(args[1:], " ")
+	switch {
+	case cmd == "show-hooks -g":
+		return []byte(r.hooks), nil
+	case strings.HasPrefix(cmd, "set-hook -g -u "):
+		r.removed = append(r.removed, args[len(args)-1])
+	}
+	return nil, nil
+}
+
+func TestRemoveStaleHooks(t *testing.T) {
+	// a process that has exited stands in for a pair that was killed
+	dead := exec.Command("true")
+	if err := dead.Run(); err != nil {
+		t.Skipf("could not run true: %s", err)
+	}
+	r := &hookRunner{hooks: fmt.Sprintf("client-resized[0] display-message mine\n"+
+		"client-resized[1] wait-for -S pair-size-%d\n"+
+		"client-resized[2] wait-for -S pair-size-%d\n"+
+		"after-set-option[3] wait-for -S pair-prefix-%d\n"+
+		"client-session-changed\n", dead.Process.Pid, os.Getpid(), dead.Process.Pid)}
+	hs := HostSession{}
+	hs.Debug = log.New(ioutil.Discard, "", 0)
+	hs.removeStaleHooks(tmux.Server{Runner: r})
+	removed := map[string]bool{}
+	for _, name := range r.removed {
+		removed[name] = true
+	}
+	if want := map[string]bool{"client-resized[1]": true, "after-set-option[3]": true}; !cmp.Equal(removed, want) {
+		t.Errorf("expected the hooks of the dead pair to be removed, got %v", r.removed)
+	}
+}
@@ -0,0 +1,287 @@
+package session
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bottlerocketlabs/pair/pkg/config"
+	"github.com/pion/webrtc/v2"
+)
+
+// identityLifetime is how long a host's certificate lasts, a new one looks like a different host to guests
+const identityLifetime = 10 * 365 * 24 * time.Hour
+
+// LoadIdentity returns the host's certificate from the config directory, creating it the first
+// time. Using the same certificate for every connection lets guests recognise the host.
+func LoadIdentity() (*webrtc.Certificate, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return nil, err
+	}
+	return loadCertificate(filepath.Join(dir, "identity.pem"))
+}
+
+func loadCertificate(path string) (*webrtc.Certificate, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return createCertificate(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read identity: %w", err)
+	}
+	var key *ecdsa.PrivateKey
+	var cert *x509.Certificate
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			break
+		}
+		switch block.Type {
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "CERTIFICATE":
+			cert, err = x509.ParseCertificate(block.Bytes)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not parse identity %s: %w", path, err)
+		}
+	}
+	if key == nil || cert == nil {
+		return nil, fmt.Errorf("identity %s needs a key and a certificate", path)
+	}
+	if time.Now().After(cert.NotAfter) {
+		return nil, fmt.Errorf("identity %s expired on %s, remove it to create a new one", path, cert.NotAfter.Format("2006-01-02"))
+	}
+	c := webrtc.CertificateFromX509(key, cert)
+	return &c, nil
+}
+
+func createCertificate(path string) (*webrtc.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate identity key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("could not generate identity serial number: %w", err)
+	}
+	tpl := x509.Certificate{
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(identityLifetime),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		SerialNumber:          serial,
+		SignatureAlgorithm:    x509.ECDSAWithSHA256,
+		Subject:               pkix.Name{CommonName: "pair"},
+		IsCA:                  true,
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &tpl, &tpl, key.Public(), key)
+	if err != nil {
+		return nil, fmt.Errorf("could not create identity certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(certDER)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse identity certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode identity key: %w", err)
+	}
+	out := append(
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})...,
+	)
+	if err := ioutil.WriteFile(path, out, 0600); err != nil {
+		return nil, fmt.Errorf("could not save identity: %w", err)
+	}
+	c := webrtc.CertificateFromX509(key, cert)
+	return &c, nil
+}
+
+// knownHosts records the certificate fingerprint each host presented the first time a guest
+// joined them, in the style of ssh's known_hosts
+type knownHosts struct {
+	path string
+}
+
+func loadKnownHosts() (knownHosts, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return knownHosts{}, err
+	}
+	return knownHosts{path: filepath.Join(dir, "known_hosts")}, nil
+}
+
+// lookup returns the fingerprint recorded for name and the line it is on, or an empty fingerprint
+func (k knownHosts) lookup(name string) (string, int, error) {
+	f, err := os.Open(k.path)
+	if os.IsNotExist(err) {
+		return "", 0, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("could not read known hosts: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.SplitN(scanner.Text(), " ", 2)
+		if len(fields) == 2 && fields[0] == name {
+			return fields[1], line, nil
+		}
+	}
+	return "", 0, scanner.Err()
+}
+
+// remember records fingerprint for name, replacing what was recorded before
+func (k knownHosts) remember(name, fingerprint string) error {
+	b, err := ioutil.ReadFile(k.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not read known hosts: %w", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if line != "" && !strings.HasPrefix(line, name+" ") {
+			lines = append(lines, line)
+		}
+	}
+	lines = append(lines, name+" "+fingerprint)
+	if err := ioutil.WriteFile(k.path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		return fmt.Errorf("could not save known hosts: %w", err)
+	}
+	return nil
+}
+
+// sdpFingerprint returns the certificate fingerprint in a session description, such as "sha-256 AB:CD:..."
+func sdpFingerprint(sdp string) string {
+	for _, line := range strings.Split(sdp, "\n") {
+		if strings.HasPrefix(line, "a=fingerprint:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "a=fingerprint:"))
+		}
+	}
+	return ""
+}
+
+var (
+	errHostKeyChanged = errors.New("host key has changed")
+	errHostKeyUnknown = errors.New("host key not trusted")
+)
+
+// hostIdentity is what the host's key is remembered under. The name is chosen by the host,
+// so through a room it is kept within the room the guest chose, anyone else using the name
+// shows up as a host the guest has not joined before.
+func hostIdentity(room, name string) string {
+	if strings.IndexFunc(name, func(r rune) bool { return r <= ' ' || r == 0x7f }) != -1 {
+		name = ""
+	}
+	if room == "" {
+		return name
+	}
+	if name == "" {
+		return room
+	}
+	return room + "#" + name
+}
+
+// checkHostIdentity compares the host's certificate with the one they presented last time,
+// the first time the guest joins them it shows the key to check with the host before joining
+func (cs *ClientSession) checkHostIdentity() error {
+	name := hostIdentity(cs.room, cs.OfferSD.Host)
+	fingerprint := sdpFingerprint(cs.OfferSD.SDP)
+	if name == "" || fingerprint == "" {
+		cs.Debug.Printf("host did not identify themselves")
+		return nil
+	}
+	hosts, err := loadKnownHosts()
+	if err != nil {
+		return err
+	}
+	known, line, err := hosts.lookup(name)
+	if err != nil {
+		return err
+	}
+	switch known {
+	case fingerprint:
+		cs.Debug.Printf("%s presented the key they used before", name)
+		return nil
+	case "":
+		_, _ = fmt.Fprintf(cs.Stderr, "[pair] first time joining %s, their key is:\n  %s\n", name, fingerprint)
+		if !cs.IsTerminal {
+			return hosts.remember(name, fingerprint)
+		}
+		_, _ = fmt.Fprintf(cs.Stderr, "Check it matches the key shown to them with the invite. Trust it and join? [y/N] ")
+		ok, err := cs.confirm()
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("%w for %s", errHostKeyUnknown, name)
+		}
+		return hosts.remember(name, fingerprint)
+	}
+	_, _ = fmt.Fprintf(cs.Stderr, `@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@
+@    WARNING: HOST IDENTIFICATION HAS CHANGED!              @
+@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@@
+%s presented a different key to last time. They may have moved
+to a new machine, or someone may be pretending to be them.
+Check with them before continuing.
+The key now is:
+  %s
+The key recorded at %s:%d is:
+  %s
+`, name, fingerprint, hosts.path, line, known)
+	if !cs.IsTerminal {
+		return fmt.Errorf("%w for %s", errHostKeyChanged, name)
+	}
+	_, _ = fmt.Fprintf(cs.Stderr, "Trust the new key and join anyway? [y/N] ")
+	ok, err := cs.confirm()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w for %s", errHostKeyChanged, name)
+	}
+	return hosts.remember(name, fingerprint)
+}
+
+// confirm reads a yes or no answer from the guest
+func (cs *ClientSession) confirm() (bool, error) {
+	answer, err := readLine(cs.Stdin)
+	if err != nil {
+		return false, fmt.Errorf("could not read answer: %w", err)
+	}
+	a := strings.ToLower(strings.TrimSpace(answer))
+	return a == "y" || a == "yes", nil
+}
+
+// readLine reads up to a newline a byte at a time, so nothing typed after it is lost from f
+func readLine(f *os.File) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := f.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return string(line), nil
+			}
+			line = append(line, b[0])
+		}
+		if err != nil {
+			return string(line), err
+		}
+	}
+}
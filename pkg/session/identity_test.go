@@ -0,0 +1,62 @@
+package session
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadCertificate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "identity.pem")
+	created, err := loadCertificate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := loadCertificate(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !created.Equals(*loaded) {
+		t.Errorf("expected the saved certificate to be loaded again")
+	}
+}
+
+func TestKnownHosts(t *testing.T) {
+	hosts := knownHosts{path: filepath.Join(t.TempDir(), "known_hosts")}
+	if fp, _, err := hosts.lookup("alice@laptop"); err != nil || fp != "" {
+		t.Fatalf("expected no known hosts: %q %v", fp, err)
+	}
+	for _, h := range [][2]string{{"alice@laptop", "sha-256 AA"}, {"bob@desktop", "sha-256 BB"}, {"alice@laptop", "sha-256 CC"}} {
+		if err := hosts.remember(h[0], h[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if fp, line, _ := hosts.lookup("alice@laptop"); fp != "sha-256 CC" || line != 2 {
+		t.Errorf("expected new key to replace the old one: %q on line %d", fp, line)
+	}
+	if fp, line, _ := hosts.lookup("bob@desktop"); fp != "sha-256 BB" || line != 1 {
+		t.Errorf("unexpected key: %q on line %d", fp, line)
+	}
+}
+
+func TestSDPFingerprint(t *testing.T) {
+	sdp := "v=0\r\no=- 1 2 IN IP4 0.0.0.0\r\na=fingerprint:sha-256 AB:CD:EF\r\na=group:BUNDLE 0\r\n"
+	if got, want := sdpFingerprint(sdp), "sha-256 AB:CD:EF"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestHostIdentity(t *testing.T) {
+	for _, test := range []struct {
+		room, name, want string
+	}{
+		{"", "alice@laptop", "alice@laptop"},
+		{"https://pair.example.com/r/team", "alice@laptop", "https://pair.example.com/r/team#alice@laptop"},
+		{"https://pair.example.com/r/team", "", "https://pair.example.com/r/team"},
+		{"", "alice@laptop sha-256 AA", ""},
+		{"https://pair.example.com/r/team", "alice\nbob", "https://pair.example.com/r/team"},
+	} {
+		if got := hostIdentity(test.room, test.name); got != test.want {
+			t.Errorf("%q %q: got %q, want %q", test.room, test.name, got, test.want)
+		}
+	}
+}
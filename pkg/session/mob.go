@@ -0,0 +1,238 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bottlerocketlabs/pair/pkg/tmux"
+)
+
+// Mob rotates who drives on a timer
+type Mob struct {
+	// Turn is how long each driver has the keyboard
+	Turn time.Duration
+	// Order is who drives in turn, anyone but the guest drives from the host's keyboard.
+	// The host then the guest drive when it is empty.
+	Order []string
+}
+
+// keys that control the rotation, they signal mobChannel for watchMob to pick up. Only
+// the driver can press them, the guest's are held back when they are not driving and the
+// host's client is read-only.
+const (
+	mobPauseKey = "P"
+	mobSkipKey  = "N"
+	mobOrderKey = "O"
+)
+
+// mobWarning is how long before handing over the driver and next driver are warned
+const mobWarning = time.Minute
+
+// mobStatus is the time left in the turn, tmux works it out from @pair-mob-until as the
+// status line is redrawn, the strftime %s in it is the time now
+const mobStatus = "#{?@pair-mob-until,#{e|/:#{e|+:#{e|-:#{@pair-mob-until},%s},59},60}m ,}#{@pair-mob}"
+
+// mob is the state of the rotation, it is only used by watchMob
+type mob struct {
+	order []string
+	turn  int
+	// until is when the turn ends, left is the time left in it while paused
+	until  time.Time
+	left   time.Duration
+	paused bool
+	warned bool
+}
+
+// mobChannel is what the key for cmd signals
+func mobChannel(cmd string) string {
+	return fmt.Sprintf("pair-mob-%s-%d", cmd, os.Getpid())
+}
+
+// mobBindings are the key bindings that control the rotation
+func mobBindings() map[string][]string {
+	return map[string][]string{
+		mobPauseKey: {"wait-for", "-S", mobChannel("pause")},
+		mobSkipKey:  {"wait-for", "-S", mobChannel("skip")},
+		mobOrderKey: {"command-prompt", "-I", "#{@pair-mob-order}", "-p", "pair rotation:", "set-option -g @pair-mob-order '%1' ; wait-for -S " + mobChannel("order")},
+	}
+}
+
+// parseMobOrder splits a list of names separated by commas or spaces
+func parseMobOrder(s string) []string {
+	return strings.Fields(strings.ReplaceAll(s, ",", " "))
+}
+
+// mobCommand is a press of one of the rotation keys on srv
+type mobCommand struct {
+	srv tmux.Server
+	cmd string
+}
+
+// watchMob hands the keyboard to the next driver each turn until done
+func (hs *HostSession) watchMob(done <-chan struct{}) {
+	m := &mob{order: hs.Mob.Order}
+	if len(m.order) == 0 {
+		m.order = []string{hs.hostDisplayName(), hs.guestDisplayName()}
+	}
+	hs.setMobOrder(m.order)
+	hs.floor.passing.Lock()
+	hs.handOver(m)
+	hs.floor.passing.Unlock()
+	cmds := make(chan mobCommand)
+	for _, srv := range hs.floorServers() {
+		for _, cmd := range []string{"pause", "skip", "order"} {
+			go func(srv tmux.Server, cmd string) {
+				hs.waitFor(srv, mobChannel(cmd), done, func() error {
+					select {
+					case cmds <- mobCommand{srv: srv, cmd: cmd}:
+					case <-done:
+					}
+					return nil
+				})
+			}(srv, cmd)
+		}
+	}
+	for {
+		var wake <-chan time.Time
+		var timer *time.Timer
+		if !m.paused {
+			d := time.Until(m.until)
+			if !m.warned {
+				d -= mobWarning
+			}
+			timer = time.NewTimer(d)
+			wake = timer.C
+		}
+		select {
+		case <-done:
+			if timer != nil {
+				timer.Stop()
+			}
+			hs.floor.mu.Lock()
+			hs.floor.mobDriver = ""
+			hs.floor.mu.Unlock()
+			_, _ = hs.sharedTmux().Run("set-option", "-gu", "@pair-mob")
+			_, _ = hs.sharedTmux().Run("set-option", "-gu", "@pair-mob-until")
+			return
+		case c := <-cmds:
+			hs.floor.passing.Lock()
+			hs.mobCommand(m, c)
+			hs.floor.passing.Unlock()
+		case <-wake:
+			hs.floor.passing.Lock()
+			if m.warned {
+				m.turn = (m.turn + 1) % len(m.order)
+				hs.handOver(m)
+			} else {
+				m.warned = true
+				hs.tellMob(fmt.Sprintf("pair: %s left for %s, next: %s", mobWarning, m.order[m.turn], m.next()))
+			}
+			hs.floor.passing.Unlock()
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+// mobCommand runs a command from one of the rotation keys
+func (hs *HostSession) mobCommand(m *mob, c mobCommand) {
+	hs.Debug.Printf("mob command: %s", c.cmd)
+	switch c.cmd {
+	case "pause":
+		m.paused = !m.paused
+		if m.paused {
+			m.left = time.Until(m.until)
+			hs.tellMob("pair: rotation paused")
+		} else {
+			m.until = time.Now().Add(m.left)
+			hs.tellMob("pair: rotation resumed")
+		}
+		hs.showMobStatus(m)
+	case "skip":
+		m.turn = (m.turn + 1) % len(m.order)
+		hs.handOver(m)
+	case "order":
+		b, err := c.srv.Run("show-options", "-gqv", "@pair-mob-order")
+		if err != nil {
+			hs.Debug.Printf("could not get rotation: %s", err)
+			return
+		}
+		order := parseMobOrder(string(b))
+		if len(order) == 0 {
+			hs.setMobOrder(m.order)
+			return
+		}
+		driver := m.order[m.turn]
+		m.order, m.turn = order, 0
+		for i, name := range order {
+			if name == driver {
+				m.turn = i
+			}
+		}
+		hs.setMobOrder(order)
+		if order[m.turn] != driver {
+			hs.handOver(m)
+			return
+		}
+		hs.tellMob(fmt.Sprintf("pair: rotation is %s", strings.Join(order, ", ")))
+		hs.showMobStatus(m)
+	}
+}
+
+// handOver gives the keyboard to whoever's turn it is for a whole turn
+func (hs *HostSession) handOver(m *mob) {
+	m.until, m.left = time.Now().Add(hs.Mob.Turn), hs.Mob.Turn
+	m.warned = hs.Mob.Turn <= mobWarning
+	driver := m.order[m.turn]
+	hs.floor.mu.Lock()
+	hs.floor.mobDriver = driver
+	hs.floor.mu.Unlock()
+	hs.setDriver(hs.isGuest(driver))
+	hs.tellMob(fmt.Sprintf("pair: next: %s, driving for %s", driver, hs.Mob.Turn))
+	hs.showMobStatus(m)
+}
+
+// next is who drives after the current driver
+func (m *mob) next() string {
+	return m.order[(m.turn+1)%len(m.order)]
+}
+
+// setMobOrder keeps the order for the prompt of mobOrderKey
+func (hs *HostSession) setMobOrder(order []string) {
+	for _, srv := range hs.floorServers() {
+		if _, err := srv.Run("set-option", "-g", "@pair-mob-order", strings.Join(order, ",")); err != nil {
+			hs.Debug.Printf("could not set rotation: %s", err)
+		}
+	}
+}
+
+// showMobStatus puts the end of the turn and the next driver in the status line, it only
+// needs calling when they change
+func (hs *HostSession) showMobStatus(m *mob) {
+	srv := hs.sharedTmux()
+	status := fmt.Sprintf("next: %s", m.next())
+	if m.paused {
+		status = "paused, " + status
+		_, _ = srv.Run("set-option", "-gu", "@pair-mob-until")
+	} else if _, err := srv.Run("set-option", "-g", "@pair-mob-until", strconv.FormatInt(m.until.Unix(), 10)); err != nil {
+		hs.Debug.Printf("could not show rotation: %s", err)
+	}
+	if _, err := srv.Run("set-option", "-g", "@pair-mob", status); err != nil {
+		hs.Debug.Printf("could not show rotation: %s", err)
+	}
+}
+
+// tellMob shows msg to the host and guest
+func (hs *HostSession) tellMob(msg string) {
+	hs.tellHost(msg)
+	hs.tellGuest(msg)
+}
+
+// isGuest is whether name in the rotation is the guest, everyone else drives from the host's keyboard
+func (hs *HostSession) isGuest(name string) bool {
+	return name == hs.guestDisplayName()
+}
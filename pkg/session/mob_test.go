@@ -0,0 +1,30 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseMobOrder(t *testing.T) {
+	tests := map[string][]string{
+		"alice,bob":           {"alice", "bob"},
+		" alice, bob ,,carol": {"alice", "bob", "carol"},
+		"alice bob":           {"alice", "bob"},
+	}
+	for in, want := range tests {
+		if got := parseMobOrder(in); !cmp.Equal(got, want) {
+			t.Errorf("parseMobOrder(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if got := parseMobOrder(" , "); len(got) != 0 {
+		t.Errorf("expected no names, got %q", got)
+	}
+}
+
+func TestMobNext(t *testing.T) {
+	m := &mob{order: []string{"alice", "bob", "carol"}, turn: 2}
+	if next := m.next(); next != "alice" {
+		t.Errorf("expected alice after carol, got %s", next)
+	}
+}
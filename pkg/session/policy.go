@@ -0,0 +1,280 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bottlerocketlabs/pair/pkg/tmux"
+)
+
+// confirmTimeout is how long the host has to allow a sequence before it is rejected
+const confirmTimeout = 30 * time.Second
+
+// InputPolicy restricts what a guest can type. Each sequence is keys named as tmux names
+// them separated by spaces, where prefix stands for the tmux prefix keys, such as "prefix d".
+type InputPolicy struct {
+	// Block is sequences that are dropped
+	Block []string
+	// Confirm is sequences the host has to allow before they are sent
+	Confirm []string
+}
+
+// inputRule is a sequence of bytes from a guest that is blocked or needs confirming
+type inputRule struct {
+	name    string
+	seq     []byte
+	confirm bool
+}
+
+// inputRules expands the sequences in policy into the bytes a guest would send for them,
+// longest first so they take precedence over sequences they start with
+func (policy InputPolicy) inputRules(prefixes [][]byte) ([]inputRule, error) {
+	var rules []inputRule
+	add := func(sequences []string, confirm bool) error {
+		for _, name := range sequences {
+			seqs := [][]byte{nil}
+			for _, key := range strings.Fields(name) {
+				options := prefixes
+				if key != "prefix" {
+					b, err := tmux.KeyBytes(key)
+					if err != nil {
+						return fmt.Errorf("could not use %q: %w", name, err)
+					}
+					options = [][]byte{b}
+				}
+				var next [][]byte
+				for _, seq := range seqs {
+					for _, o := range options {
+						next = append(next, append(append([]byte{}, seq...), o...))
+					}
+				}
+				seqs = next
+			}
+			for _, seq := range seqs {
+				if len(seq) > 0 {
+					rules = append(rules, inputRule{name: name, seq: seq, confirm: confirm})
+				}
+			}
+		}
+		return nil
+	}
+	if err := add(policy.Block, false); err != nil {
+		return nil, err
+	}
+	if err := add(policy.Confirm, true); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return len(rules[i].seq) > len(rules[j].seq)
+	})
+	return rules, nil
+}
+
+// maxHeldInput is the most input held back while the host is asked to allow a sequence,
+// input beyond it is dropped
+const maxHeldInput = 4096
+
+// inputFilter applies rules to a guest's input as it arrives, holding back input that could
+// be the start of a sequence until the rest of it arrives
+type inputFilter struct {
+	rules   []inputRule
+	pending []byte
+	// confirming is set while the host is asked to allow a sequence, input is held in
+	// pending until they answer
+	confirming bool
+}
+
+// filtered is what is left of input after the rules are applied
+type filtered struct {
+	allowed []byte
+	// blocked is the names of the sequences that were dropped
+	blocked []string
+	// confirm is a sequence to ask the host about, input after it is held until they answer
+	confirm *inputRule
+	// dropped is how many bytes of input were dropped rather than held for the host's answer
+	dropped int
+}
+
+func (f *inputFilter) filter(input []byte) filtered {
+	var out filtered
+	if f.confirming {
+		if room := maxHeldInput - len(f.pending); len(input) > room {
+			out.dropped = len(input) - room
+			input = input[:room]
+		}
+		f.pending = append(f.pending, input...)
+		return out
+	}
+	buf := append(f.pending, input...)
+	f.pending = nil
+scan:
+	for i := 0; i < len(buf); {
+		for _, rule := range f.rules {
+			if !bytes.HasPrefix(buf[i:], rule.seq) {
+				continue
+			}
+			if rule.confirm {
+				rule := rule
+				out.confirm = &rule
+				f.pending = append([]byte{}, buf[i+len(rule.seq):]...)
+				f.confirming = true
+				break scan
+			}
+			out.blocked = append(out.blocked, rule.name)
+			i += len(rule.seq)
+			continue scan
+		}
+		for _, rule := range f.rules {
+			if bytes.HasPrefix(rule.seq, buf[i:]) {
+				f.pending = append([]byte{}, buf[i:]...)
+				break scan
+			}
+		}
+		out.allowed = append(out.allowed, buf[i])
+		i++
+	}
+	return out
+}
+
+// answered ends confirming, filtering the input held while the host was asked when they
+// allowed the sequence, or dropping it when they did not
+func (f *inputFilter) answered(allowed bool) filtered {
+	held := f.pending
+	f.pending, f.confirming = nil, false
+	if !allowed {
+		return filtered{dropped: len(held)}
+	}
+	return f.filter(held)
+}
+
+// writeGuestInput writes a guest's input to the pty, once floor control and the InputPolicy
+// have been applied to it
+func (hs *HostSession) writeGuestInput(input []byte) error {
+	hs.inputMu.Lock()
+	defer hs.inputMu.Unlock()
+	input = hs.gateGuestInput(input)
+	if hs.inputFilter != nil {
+		input = hs.applyFiltered(hs.inputFilter.filter(input))
+	}
+	_, err := hs.Pty.Write(input)
+	return err
+}
+
+// applyFiltered reports what the InputPolicy dropped to the guest and asks the host about
+// anything that needs confirming, returning the input that can be sent
+func (hs *HostSession) applyFiltered(f filtered) []byte {
+	for _, name := range f.blocked {
+		hs.rejectInput(fmt.Sprintf("%s is blocked by the host", name))
+	}
+	if f.dropped > 0 {
+		hs.rejectInput(fmt.Sprintf("%d bytes you typed were dropped while waiting for the host", f.dropped))
+	}
+	if f.confirm != nil {
+		hs.tellGuest(fmt.Sprintf("pair: asking the host to allow %s, what you type is held until they answer", f.confirm.name))
+		go hs.confirmInput(*f.confirm)
+	}
+	return f.allowed
+}
+
+// confirmInput asks the host whether the guest can send rule's sequence, sending it and
+// what the guest typed after it if so
+func (hs *HostSession) confirmInput(rule inputRule) {
+	allowed := hs.askHost(fmt.Sprintf("pair: let guest press %s? (y/n)", rule.name))
+	hs.inputMu.Lock()
+	defer hs.inputMu.Unlock()
+	f := hs.inputFilter.answered(allowed)
+	if !allowed {
+		reason := fmt.Sprintf("the host did not allow %s", rule.name)
+		if f.dropped > 0 {
+			reason += fmt.Sprintf(", the %d bytes you typed after it were dropped", f.dropped)
+		}
+		hs.rejectInput(reason)
+		return
+	}
+	input := append(append([]byte{}, rule.seq...), hs.applyFiltered(f)...)
+	if _, err := hs.Pty.Write(input); err != nil {
+		hs.Debug.Printf("could not write confirmed input to pty: %s", err)
+	}
+}
+
+// loadInputRules expands the InputPolicy with the tmux prefix keys as they are now
+func (hs *HostSession) loadInputRules() error {
+	prefixes, err := hs.sharedTmux().Prefixes()
+	if err != nil {
+		return err
+	}
+	rules, err := hs.InputPolicy.inputRules(prefixes)
+	if err != nil {
+		return fmt.Errorf("could not apply input policy: %w", err)
+	}
+	hs.inputMu.Lock()
+	defer hs.inputMu.Unlock()
+	hs.inputRules = rules
+	if hs.inputFilter != nil {
+		hs.inputFilter.rules = rules
+	}
+	return nil
+}
+
+// watchPrefixes reloads the input rules whenever an option is set, in case it was the prefix,
+// until done
+func (hs *HostSession) watchPrefixes(done <-chan struct{}) {
+	channel := fmt.Sprintf("pair-prefix-%d", os.Getpid())
+	err := hs.watchHook(hs.sharedTmux(), "after-set-option", channel, done, func() error {
+		if err := hs.loadInputRules(); err != nil {
+			hs.Debug.Printf("keeping input rules for the old prefix: %s", err)
+		}
+		return nil
+	})
+	if err != nil {
+		hs.Debug.Printf("not watching for changes to the prefix: %s", err)
+	}
+}
+
+// askHost prompts the host's tmux client for a y or n, anything but y within confirmTimeout is a no
+func (hs *HostSession) askHost(prompt string) bool {
+	if hs.HostState.Client == "" {
+		return false
+	}
+	hs.confirmations++
+	id := fmt.Sprintf("pair-confirm-%d-%d", os.Getpid(), hs.confirmations)
+	template := fmt.Sprintf("set-option -gq @%s '%%1' ; wait-for -S %s", id, id)
+	if _, err := hs.Tmux.Run("command-prompt", "-1", "-t", hs.HostState.Client, "-p", prompt, template); err != nil {
+		hs.Debug.Printf("could not ask host: %s", err)
+		return false
+	}
+	answered := make(chan struct{})
+	go func() {
+		_, _ = hs.Tmux.Run("wait-for", id)
+		close(answered)
+	}()
+	select {
+	case <-answered:
+	case <-time.After(confirmTimeout):
+		// wake the waiter, the option is not set so this counts as a no
+		_, _ = hs.Tmux.Run("wait-for", "-S", id)
+		<-answered
+	}
+	answer, err := hs.Tmux.Run("show-options", "-gqv", "@"+id)
+	_, _ = hs.Tmux.Run("set-option", "-gu", "@"+id)
+	if err != nil {
+		hs.Debug.Printf("could not read host's answer: %s", err)
+		return false
+	}
+	return strings.TrimSpace(string(answer)) == "y"
+}
+
+// rejectInput tells the guest some of their input was not sent
+func (hs *HostSession) rejectInput(reason string) {
+	hs.Debug.Printf("rejected guest input: %s", reason)
+	msg, _ := json.Marshal([]string{"rejected", reason})
+	if err := hs.DataChannel.SendText(string(msg)); err != nil {
+		hs.Debug.Printf("could not tell guest input was rejected: %s", err)
+	}
+	hs.tellGuest("pair: " + reason)
+}
@@ -0,0 +1,79 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestInputFilter(t *testing.T) {
+	policy := InputPolicy{Block: []string{"prefix d", "prefix :", "C-d"}, Confirm: []string{"prefix &"}}
+	rules, err := policy.inputRules([][]byte{{0x02}, {0x01}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := inputFilter{rules: rules}
+
+	out := f.filter([]byte("ls\r\x02c\x01d"))
+	if string(out.allowed) != "ls\r\x02c" || !cmp.Equal(out.blocked, []string{"prefix d"}) || out.confirm != nil {
+		t.Errorf("unexpected result %+v", out)
+	}
+	// the prefix is held until the next key arrives
+	out = f.filter([]byte("\x02"))
+	if len(out.allowed) != 0 || len(out.blocked) != 0 {
+		t.Errorf("expected prefix to be held, got %+v", out)
+	}
+	out = f.filter([]byte(":kill-server\r"))
+	if string(out.allowed) != "kill-server\r" || !cmp.Equal(out.blocked, []string{"prefix :"}) {
+		t.Errorf("unexpected result %+v", out)
+	}
+	out = f.filter([]byte("\x02"))
+	out = f.filter([]byte("n"))
+	if string(out.allowed) != "\x02n" {
+		t.Errorf("expected held prefix to be sent with the next key, got %+v", out)
+	}
+	out = f.filter([]byte("a\x04b"))
+	if string(out.allowed) != "ab" || !cmp.Equal(out.blocked, []string{"C-d"}) {
+		t.Errorf("unexpected result %+v", out)
+	}
+	out = f.filter([]byte("x\x02&y"))
+	if string(out.allowed) != "x" || out.confirm == nil || string(out.confirm.seq) != "\x02&" || out.confirm.name != "prefix &" {
+		t.Errorf("expected prefix & to need confirming, got %+v", out)
+	}
+	// what is typed while the host is asked is held, including what came after the sequence
+	out = f.filter([]byte("es\x02d"))
+	if len(out.allowed) != 0 || len(out.blocked) != 0 || out.dropped != 0 {
+		t.Errorf("expected input to be held while confirming, got %+v", out)
+	}
+	out = f.answered(true)
+	if string(out.allowed) != "yes" || !cmp.Equal(out.blocked, []string{"prefix d"}) {
+		t.Errorf("expected held input to be filtered once allowed, got %+v", out)
+	}
+	f.filter([]byte("\x02&no"))
+	if out = f.answered(false); out.dropped != 2 || len(out.allowed) != 0 {
+		t.Errorf("expected held input to be dropped when not allowed, got %+v", out)
+	}
+	f.filter([]byte("\x02&"))
+	if out = f.filter(make([]byte, maxHeldInput+10)); out.dropped != 10 {
+		t.Errorf("expected input beyond %d bytes to be dropped, got %+v", maxHeldInput, out)
+	}
+	if out = f.answered(true); len(out.allowed) != maxHeldInput {
+		t.Errorf("expected %d bytes to be sent, got %d", maxHeldInput, len(out.allowed))
+	}
+
+	if _, err := (InputPolicy{Block: []string{"prefix F1"}}).inputRules([][]byte{{0x02}}); err == nil {
+		t.Errorf("expected unsupported key to fail")
+	}
+}
+
+func TestBlockPrefix(t *testing.T) {
+	rules, err := InputPolicy{Block: []string{"prefix", "prefix d"}}.inputRules([][]byte{{0x02}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := inputFilter{rules: rules}
+	out := f.filter([]byte("\x02dx\x02"))
+	if string(out.allowed) != "x" || !cmp.Equal(out.blocked, []string{"prefix d", "prefix"}) {
+		t.Errorf("unexpected result %+v", out)
+	}
+}
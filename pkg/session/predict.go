@@ -0,0 +1,336 @@
+package session
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// When the guest shows their typing before the host echoes it back
+const (
+	PredictAdaptive = "adaptive"
+	PredictAlways   = "always"
+	PredictNever    = "never"
+)
+
+// adaptive prediction turns on above predictOn and back off below predictOff,
+// the gap stops it flickering on and off on a link close to the threshold
+const (
+	predictOn  = 60 * time.Millisecond
+	predictOff = 40 * time.Millisecond
+)
+
+// prediction is a keystroke sent to the host that has not been echoed yet,
+// move is non zero for cursor motion rather than a character
+type prediction struct {
+	char byte
+	move int
+	at   time.Time
+}
+
+// predictor speculatively echoes printable keystrokes and cursor motion, shown underlined,
+// and takes them back before writing the real output from the host. Like mosh, nothing
+// is shown after enter or another control key until a keystroke has been echoed by the
+// host, so what is typed at a password prompt is not shown.
+//
+// Without a copy of the screen it cannot put back what a wrong prediction covered, so it
+// reports a glitch and the host is asked to redraw the guest's terminal.
+type predictor struct {
+	mu   sync.Mutex
+	out  io.Writer
+	mode string
+	now  func() time.Time
+
+	srtt time.Duration
+	on   bool
+
+	pending []prediction
+	// confirmed is set once the host echoed a prediction since the last control key
+	confirmed bool
+	// written cells and offset of the cursor from where the host left it
+	written int
+	offset  int
+	// output holds the state of escape sequences split across writes
+	output escapeState
+}
+
+func newPredictor(out io.Writer, mode string) *predictor {
+	return &predictor{out: out, mode: mode, now: time.Now}
+}
+
+// SetRTT records a round trip time measured to the host
+func (p *predictor) SetRTT(sample time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.srtt == 0 {
+		p.srtt = sample
+	} else {
+		p.srtt = (7*p.srtt + sample) / 8
+	}
+	if !p.on && p.srtt > predictOn {
+		p.on = true
+		p.confirmed = false
+	} else if p.on && p.srtt < predictOff {
+		p.on = false
+	}
+}
+
+// RTT is the smoothed round trip time to the host
+func (p *predictor) RTT() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.srtt
+}
+
+func (p *predictor) active() bool {
+	return p.mode == PredictAlways || (p.mode == PredictAdaptive && p.on)
+}
+
+// expiry is how long to wait for the host to echo a prediction before it is wrong
+func (p *predictor) expiry() time.Duration {
+	return 2*p.srtt + 250*time.Millisecond
+}
+
+// Input predicts the effect of keystrokes the guest has sent to the host
+func (p *predictor) Input(b []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var show bytes.Buffer
+	now := p.now()
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c >= 0x20 && c < 0x7f:
+			if !p.active() || p.moved() {
+				p.confirmed = false
+				continue
+			}
+			p.pending = append(p.pending, prediction{char: c, at: now})
+			if p.confirmed {
+				fmt.Fprintf(&show, "\x1b[4m%c\x1b[24m", c)
+				p.written++
+				p.offset++
+			}
+		case c == 0x1b && i+2 < len(b) && (b[i+1] == '[' || b[i+1] == 'O') && (b[i+2] == 'C' || b[i+2] == 'D'):
+			i += 2
+			move := 1
+			if b[i] == 'D' {
+				move = -1
+			}
+			// only move over predicted text, the cursor could be at the edge of anything else
+			if !p.active() || !p.confirmed || p.offset+move < 0 || p.offset+move > p.written {
+				p.confirmed = false
+				continue
+			}
+			p.pending = append(p.pending, prediction{move: move, at: now})
+			p.offset += move
+			if move < 0 {
+				show.WriteString("\x1b[D")
+			} else {
+				show.WriteString("\x1b[C")
+			}
+		default:
+			p.confirmed = false
+			if c == 0x1b {
+				i += escapeLength(b[i:]) - 1
+			}
+		}
+	}
+	if show.Len() == 0 {
+		return nil
+	}
+	_, err := p.out.Write(show.Bytes())
+	return err
+}
+
+// moved reports whether cursor motion is predicted, the host may insert rather than overwrite
+// characters typed after it so they are not predicted
+func (p *predictor) moved() bool {
+	for _, pr := range p.pending {
+		if pr.move != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Output writes output from the host, taking back predictions first and showing those
+// still waiting afterwards. It reports a glitch when a prediction turned out to be wrong.
+func (p *predictor) Output(b []byte) (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var buf bytes.Buffer
+	shown := p.undo(&buf)
+	buf.Write(b)
+	p.confirm(p.output.printable(b))
+	glitch := p.expire() && shown
+	p.redo(&buf)
+	_, err := p.out.Write(buf.Bytes())
+	return glitch, err
+}
+
+// Tick takes back predictions the host has not echoed in time
+func (p *predictor) Tick() (bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.pending) == 0 || p.now().Sub(p.pending[0].at) <= p.expiry() {
+		return false, nil
+	}
+	var buf bytes.Buffer
+	shown := p.undo(&buf)
+	p.expire()
+	if buf.Len() == 0 {
+		return false, nil
+	}
+	_, err := p.out.Write(buf.Bytes())
+	return shown, err
+}
+
+// undo moves the cursor back to where the host left it and erases the predicted cells
+func (p *predictor) undo(buf *bytes.Buffer) bool {
+	shown := p.written > 0 || p.offset != 0
+	if p.offset > 0 {
+		fmt.Fprintf(buf, "\x1b[%dD", p.offset)
+	} else if p.offset < 0 {
+		fmt.Fprintf(buf, "\x1b[%dC", -p.offset)
+	}
+	if p.written > 0 {
+		fmt.Fprintf(buf, "\x1b[%dX", p.written)
+	}
+	p.written, p.offset = 0, 0
+	return shown
+}
+
+// redo shows the predictions still waiting from where the host left the cursor
+func (p *predictor) redo(buf *bytes.Buffer) {
+	if !p.confirmed || !p.active() {
+		return
+	}
+	for _, pr := range p.pending {
+		switch {
+		case pr.move < 0:
+			buf.WriteString("\x1b[D")
+		case pr.move > 0:
+			buf.WriteString("\x1b[C")
+		default:
+			fmt.Fprintf(buf, "\x1b[4m%c\x1b[24m", pr.char)
+			p.written++
+		}
+		p.offset += pr.move
+		if pr.move == 0 {
+			p.offset++
+		}
+	}
+}
+
+// confirm removes the predicted characters the host has echoed in text, an echo starts or ends
+// the output so that a prompt such as "Password: " does not confirm what is typed into it
+func (p *predictor) confirm(text []byte) {
+	var chars []byte
+	for _, pr := range p.pending {
+		if pr.move != 0 {
+			break
+		}
+		chars = append(chars, pr.char)
+	}
+	n := len(chars)
+	for ; n > 0; n-- {
+		if bytes.HasPrefix(text, chars[:n]) || bytes.HasSuffix(text, chars[:n]) {
+			break
+		}
+	}
+	if n > 0 {
+		p.pending = p.pending[n:]
+		p.confirmed = true
+	}
+	// cursor motion after echoed characters has been applied by the host by now
+	if n == len(chars) {
+		p.pending = nil
+	}
+}
+
+// expire drops all predictions if the oldest has waited too long to be echoed
+func (p *predictor) expire() bool {
+	if len(p.pending) == 0 || p.now().Sub(p.pending[0].at) <= p.expiry() {
+		return false
+	}
+	p.pending = nil
+	p.confirmed = false
+	return true
+}
+
+// escapeState tracks an escape sequence in terminal output
+type escapeState int
+
+const (
+	escNone escapeState = iota
+	escStart
+	escCSI
+	escString
+	escStringEnd
+	escCharset
+)
+
+// printable returns the text of terminal output without escape sequences or control characters
+func (s *escapeState) printable(b []byte) []byte {
+	var text []byte
+	for _, c := range b {
+		switch *s {
+		case escNone:
+			if c == 0x1b {
+				*s = escStart
+			} else if c >= 0x20 && c != 0x7f {
+				text = append(text, c)
+			}
+		case escStart:
+			switch c {
+			case '[':
+				*s = escCSI
+			case ']', 'P', '_', '^', 'X':
+				*s = escString
+			case '(', ')', '*', '+', '#', '%':
+				*s = escCharset
+			default:
+				*s = escNone
+			}
+		case escCSI:
+			if c >= 0x40 && c <= 0x7e {
+				*s = escNone
+			}
+		case escString:
+			if c == 0x07 {
+				*s = escNone
+			} else if c == 0x1b {
+				*s = escStringEnd
+			}
+		case escStringEnd:
+			*s = escNone
+		case escCharset:
+			*s = escNone
+		}
+	}
+	return text
+}
+
+// escapeLength is the length of the escape sequence at the start of a keystroke
+func escapeLength(b []byte) int {
+	if len(b) < 2 {
+		return len(b)
+	}
+	switch b[1] {
+	case '[':
+		for i := 2; i < len(b); i++ {
+			if b[i] >= 0x40 && b[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return len(b)
+	case 'O':
+		if len(b) > 2 {
+			return 3
+		}
+	}
+	return 2
+}
@@ -0,0 +1,176 @@
+package session
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func newTestPredictor(mode string) (*predictor, *bytes.Buffer, *fakeClock) {
+	var out bytes.Buffer
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	p := newPredictor(&out, mode)
+	p.now = clock.now
+	p.SetRTT(100 * time.Millisecond)
+	return p, &out, clock
+}
+
+func TestPredictAdaptive(t *testing.T) {
+	p := newPredictor(&bytes.Buffer{}, PredictAdaptive)
+	p.SetRTT(20 * time.Millisecond)
+	if p.active() {
+		t.Errorf("predicting on a fast link")
+	}
+	for i := 0; i < 20; i++ {
+		p.SetRTT(150 * time.Millisecond)
+	}
+	if !p.active() {
+		t.Errorf("not predicting on a slow link, rtt %s", p.RTT())
+	}
+	// stays on between the thresholds
+	for i := 0; i < 20; i++ {
+		p.SetRTT(50 * time.Millisecond)
+	}
+	if !p.active() {
+		t.Errorf("stopped predicting above the off threshold, rtt %s", p.RTT())
+	}
+	for i := 0; i < 20; i++ {
+		p.SetRTT(10 * time.Millisecond)
+	}
+	if p.active() {
+		t.Errorf("still predicting on a fast link, rtt %s", p.RTT())
+	}
+	never := newPredictor(&bytes.Buffer{}, PredictNever)
+	never.SetRTT(time.Second)
+	if never.active() {
+		t.Errorf("predicting when set to never")
+	}
+}
+
+func TestPredictEcho(t *testing.T) {
+	p, out, _ := newTestPredictor(PredictAdaptive)
+
+	// nothing is shown until the host has echoed something
+	p.Input([]byte("l"))
+	if out.Len() != 0 {
+		t.Errorf("prediction shown before echo confirmed: %q", out.String())
+	}
+	p.Output([]byte("l"))
+	if got := out.String(); got != "l" {
+		t.Errorf("got %q, want %q", got, "l")
+	}
+	out.Reset()
+
+	p.Input([]byte("s -"))
+	if got, want := out.String(), "\x1b[4ms\x1b[24m\x1b[4m \x1b[24m\x1b[4m-\x1b[24m"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	out.Reset()
+
+	// the host echoes the first two, the last is shown again after the output
+	glitch, _ := p.Output([]byte("\x1b[1ms\x1b[0m "))
+	if glitch {
+		t.Errorf("unexpected glitch")
+	}
+	if got, want := out.String(), "\x1b[3D\x1b[3X\x1b[1ms\x1b[0m \x1b[4m-\x1b[24m"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	out.Reset()
+	p.Output([]byte("-"))
+	if got, want := out.String(), "\x1b[1D\x1b[1X-"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if len(p.pending) != 0 {
+		t.Errorf("predictions left over: %+v", p.pending)
+	}
+}
+
+func TestPredictControlKeys(t *testing.T) {
+	p, out, _ := newTestPredictor(PredictAlways)
+	p.Input([]byte("a"))
+	p.Output([]byte("a"))
+	out.Reset()
+
+	// enter starts again without showing predictions, such as for a password prompt
+	p.Input([]byte("\rsecret"))
+	if out.Len() != 0 {
+		t.Errorf("prediction shown after enter: %q", out.String())
+	}
+	p.Output([]byte("\r\nPassword: "))
+	if got, want := out.String(), "\r\nPassword: "; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPredictCursorMotion(t *testing.T) {
+	p, out, _ := newTestPredictor(PredictAlways)
+	p.Input([]byte("a"))
+	p.Output([]byte("a"))
+	out.Reset()
+
+	p.Input([]byte("bc\x1b[D\x1bOD"))
+	if got, want := out.String(), "\x1b[4mb\x1b[24m\x1b[4mc\x1b[24m\x1b[D\x1b[D"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	out.Reset()
+	// cannot move before the predicted text or type after moving
+	p.Input([]byte("\x1b[Dx"))
+	if out.Len() != 0 {
+		t.Errorf("unexpected prediction: %q", out.String())
+	}
+	p.Output([]byte("bc\b\b"))
+	if got, want := out.String(), "\x1b[2X"+"bc\b\b"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if len(p.pending) != 0 {
+		t.Errorf("predictions left over: %+v", p.pending)
+	}
+}
+
+func TestPredictGlitch(t *testing.T) {
+	p, out, clock := newTestPredictor(PredictAlways)
+	p.Input([]byte("i"))
+	p.Output([]byte("i"))
+	out.Reset()
+
+	// in vim normal mode j moves the cursor instead of being echoed
+	p.Input([]byte("j"))
+	clock.t = clock.t.Add(100 * time.Millisecond)
+	glitch, _ := p.Output([]byte("\x1b[2;1H"))
+	if glitch {
+		t.Errorf("glitch before prediction expired")
+	}
+	out.Reset()
+	clock.t = clock.t.Add(time.Second)
+	glitch, _ = p.Tick()
+	if !glitch {
+		t.Errorf("expected glitch once prediction expired")
+	}
+	if got, want := out.String(), "\x1b[1D\x1b[1X"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if p.confirmed || len(p.pending) != 0 {
+		t.Errorf("predictions not reset after glitch")
+	}
+}
+
+func TestPrintable(t *testing.T) {
+	var s escapeState
+	got := s.printable([]byte("a\x1b[1;31mb\x1b]0;title\x07c\x1b(Bd\r\ne\x1b["))
+	if string(got) != "abcde" {
+		t.Errorf("got %q, want %q", got, "abcde")
+	}
+	// the sequence continues in the next write
+	got = s.printable([]byte("0mf"))
+	if string(got) != "f" {
+		t.Errorf("got %q, want %q", got, "f")
+	}
+}
@@ -0,0 +1,156 @@
+package session
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bottlerocketlabs/pair/pkg/config"
+	"github.com/bottlerocketlabs/pair/pkg/handlers"
+	"github.com/bottlerocketlabs/pair/pkg/random"
+	"golang.org/x/term"
+)
+
+// roomKey is this host's key for the room on the server, it is kept in the config directory
+// so the same host can use the room again. Teammates each have their own, an owner of the
+// room lets them host in it by listing their RoomKeyID in RoomOwners.
+func roomKey(server, name string) (string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return "", fmt.Errorf("badly formed sdp server %s: %w", server, err)
+	}
+	dir, err := config.Dir("rooms", u.Host)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, name+".key")
+	b, err := ioutil.ReadFile(path)
+	if err == nil {
+		return strings.TrimSpace(string(b)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("could not read room key: %w", err)
+	}
+	key, err := random.String(32)
+	if err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("could not save room key: %w", err)
+	}
+	return key, nil
+}
+
+// hostRoom points the room at the current invite, claiming it the first time
+func (hs *HostSession) hostRoom(policy handlers.InvitePolicy) error {
+	key, err := roomKey(hs.SDPServer, hs.Room)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPut, handlers.RoomURL(hs.SDPServer, hs.Room), strings.NewReader(hs.invite))
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+	policy.SetHeaders(req.Header)
+	req.Header.Set(handlers.RoomKeyHeader, key)
+	if hs.RoomOwners != nil {
+		req.Header.Set(handlers.RoomOwnersHeader, strings.Join(hs.RoomOwners, ","))
+	}
+	if hs.RoomPassword != nil {
+		req.Header.Set(handlers.RoomPasswordHeader, *hs.RoomPassword)
+	}
+	req.Header.Set("User-Agent", hs.UserAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not host in room %s: %w", hs.Room, err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusForbidden {
+		return fmt.Errorf("could not host in room %s: %s, ask an owner to host with -room-owners %s", hs.Room, strings.TrimSpace(string(body)), handlers.RoomKeyID(key))
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("could not host in room %s: %s", hs.Room, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// leaveRoom stops guests joining through the room, it stays claimed for next time
+func (hs *HostSession) leaveRoom() error {
+	key, err := roomKey(hs.SDPServer, hs.Room)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodDelete, handlers.RoomURL(hs.SDPServer, hs.Room), nil)
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set(handlers.RoomKeyHeader, key)
+	req.Header.Set("User-Agent", hs.UserAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not leave room %s: %w", hs.Room, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("could not leave room %s: [%s]", hs.Room, resp.Status)
+	}
+	return nil
+}
+
+// isRoomURL reports whether u is a room rather than an invite
+func isRoomURL(u string) bool {
+	parsed, err := url.Parse(u)
+	return err == nil && strings.HasPrefix(parsed.Path, "/r/")
+}
+
+// resolveRoom finds the invite of whoever is hosting in the room, asking for the room's
+// password if it has one and none was given
+func (cs *ClientSession) resolveRoom(room string) (string, error) {
+	password := cs.RoomPassword
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequest(http.MethodGet, room, nil)
+		if err != nil {
+			return "", fmt.Errorf("could not build request: %w", err)
+		}
+		if password != "" {
+			req.Header.Set(handlers.RoomPasswordHeader, password)
+		}
+		req.Header.Set("User-Agent", cs.UserAgent)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("could not join room %s: %w", room, err)
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusUnauthorized && cs.IsTerminal && attempt < 3 {
+			if password, err = cs.readPassword(room); err != nil {
+				return "", err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("could not join room %s: %s", room, strings.TrimSpace(string(body)))
+		}
+		u, err := url.Parse(room)
+		if err != nil {
+			return "", fmt.Errorf("badly formed room url %s: %w", room, err)
+		}
+		u.Path = strings.TrimSpace(string(body))
+		return u.String(), nil
+	}
+}
+
+func (cs *ClientSession) readPassword(room string) (string, error) {
+	_, _ = fmt.Fprintf(cs.Stderr, "Password for %s: ", room)
+	b, err := term.ReadPassword(int(cs.Stdin.Fd()))
+	_, _ = fmt.Fprintln(cs.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("could not read password: %w", err)
+	}
+	return string(b), nil
+}
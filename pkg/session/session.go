@@ -3,14 +3,20 @@ package session
 import (
 	"bytes"
 	"compress/zlib"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/bottlerocketlabs/pair/pkg/handlers"
 	"github.com/btcsuite/btcutil/base58"
 	"github.com/pion/webrtc/v2"
 	"golang.org/x/term"
@@ -20,6 +26,10 @@ type SessionDescription struct {
 	SDP          string
 	SDPURI       string
 	SDPAnswerURI string
+	// Host names the host, guests check the certificate in SDP is the one they saw before
+	Host string
+	// Signature is the host's ssh key signing everything else, so guests know the invite is from them
+	Signature *authSignature `json:",omitempty"`
 }
 
 func (sd SessionDescription) Encode() (string, error) {
@@ -62,6 +72,9 @@ func (sd *SessionDescription) Decode(offer string) error {
 	return nil
 }
 
+// DefaultSDPServer is the server used to exchange session descriptions unless another is chosen
+const DefaultSDPServer = "https://pair-server-sw.herokuapp.com"
+
 type Session struct {
 	Stdin, Stdout, Stderr *os.File
 	Verbose               bool
@@ -76,6 +89,13 @@ type Session struct {
 	OfferSD               SessionDescription
 	AnswerSD              SessionDescription
 	DataChannel           *webrtc.DataChannel
+	// Certificate is used for every connection when set, otherwise each gets a new one
+	Certificate *webrtc.Certificate
+	// History is the file a summary of each session is appended to, none are kept when empty
+	History string
+
+	// connected is when the data channel opened
+	connected time.Time
 }
 
 func (s *Session) init() error {
@@ -87,6 +107,26 @@ func (s *Session) init() error {
 	return nil
 }
 
+// finish stops the session with err without blocking if it is already stopping
+func (s *Session) finish(err error) {
+	select {
+	case s.ErrorChan <- err:
+	default:
+	}
+}
+
+// finisher returns finish for the current connection, so callbacks from an earlier
+// connection cannot stop a later one
+func (s *Session) finisher() func(error) {
+	errc := s.ErrorChan
+	return func(err error) {
+		select {
+		case errc <- err:
+		default:
+		}
+	}
+}
+
 func (s *Session) getSDP(url string) ([]byte, error) {
 	var body []byte
 	req, err := http.NewRequest(http.MethodGet, url, nil)
@@ -100,6 +140,9 @@ func (s *Session) getSDP(url string) ([]byte, error) {
 	}
 	defer resp.Body.Close()
 	body, err = ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusGone {
+		return body, fmt.Errorf("invite is no longer valid, ask your host for a new one: %s", strings.TrimSpace(string(body)))
+	}
 	if resp.StatusCode != http.StatusOK {
 		return body, fmt.Errorf("unexpected response code from sdp server: [%s] %s", resp.Status, string(body))
 	}
@@ -109,11 +152,84 @@ func (s *Session) getSDP(url string) ([]byte, error) {
 	return body, nil
 }
 
-func (s *Session) putSDP(url string, body io.Reader) error {
+// pollWait is how long the sdp server is asked to hold each poll for an answer, under the
+// 30 second timeout of routers such as Heroku's
+const pollWait = 25 * time.Second
+
+// pollDelay is the least time between polls, and pollMaxBackoff the most when the server is failing
+var (
+	pollDelay      = time.Second
+	pollMaxBackoff = 30 * time.Second
+)
+
+// pollSDP waits for a session description to be left in the mailbox at url until deadline,
+// riding out server errors and dropped connections
+func (s *Session) pollSDP(url string, deadline time.Time) ([]byte, error) {
+	backoff := pollDelay
+	for time.Now().Before(deadline) {
+		body, status, err := s.pollOnce(url)
+		switch {
+		case err != nil || status >= http.StatusInternalServerError:
+			s.Debug.Printf("polling sdp server again in %s: [%d] %v %s", backoff, status, err, body)
+			sleepUntil(backoff, deadline)
+			if backoff *= 2; backoff > pollMaxBackoff {
+				backoff = pollMaxBackoff
+			}
+			continue
+		case status == http.StatusNoContent:
+			backoff = pollDelay
+			sleepUntil(pollDelay, deadline)
+			continue
+		case status != http.StatusOK:
+			return body, fmt.Errorf("unexpected response code from sdp server: [%d] %s", status, string(body))
+		}
+		return body, nil
+	}
+	return nil, fmt.Errorf("invite expired before guest joined")
+}
+
+// pollOnce asks the sdp server for the message at url, waiting up to pollWait for it
+func (s *Session) pollOnce(url string) ([]byte, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pollWait+10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s?wait=%d", url, int(pollWait.Seconds())), nil)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("User-Agent", s.UserAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, 0, fmt.Errorf("could not fetch sdp response from %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return body, resp.StatusCode, fmt.Errorf("could not get body of response for sdp: [%s] %w", resp.Status, err)
+	}
+	return body, resp.StatusCode, nil
+}
+
+// sleepUntil sleeps for d, or until deadline if that is sooner
+func sleepUntil(d time.Duration, deadline time.Time) {
+	if left := time.Until(deadline); left < d {
+		d = left
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// errInviteGone is returned when the server will not take another offer for an invite
+var errInviteGone = errors.New("invite is no longer valid")
+
+// putSDP leaves a session description in the mailbox at url, policy limits how it can be
+// collected when this creates the mailbox
+func (s *Session) putSDP(url string, body io.Reader, policy handlers.InvitePolicy) error {
 	req, err := http.NewRequest(http.MethodPut, url, body)
 	if err != nil {
 		return fmt.Errorf("could not build request: %w", err)
 	}
+	policy.SetHeaders(req.Header)
 	req.Header.Set("Content-Type", "text/plain")
 	req.Header.Set("User-Agent", s.UserAgent)
 	resp, err := http.DefaultClient.Do(req)
@@ -125,12 +241,72 @@ func (s *Session) putSDP(url string, body io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("could not read body of response: %w", err)
 	}
+	if resp.StatusCode == http.StatusGone {
+		return fmt.Errorf("%w: %s", errInviteGone, strings.TrimSpace(string(content)))
+	}
 	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("unexpected response code from sdp server: [%s] %q %s", resp.Status, url, content)
 	}
 	return nil
 }
 
+// registerCode gives the invite a short code on the server that lasts as long as the invite,
+// up to handlers.MaxCodeTTL
+func (s *Session) registerCode(invite string, policy handlers.InvitePolicy) (string, error) {
+	secret, err := handlers.NewShortCodeSecret()
+	if err != nil {
+		return "", fmt.Errorf("could not choose short code: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, handlers.ShortCodeURL(s.SDPServer, 0), strings.NewReader(invite))
+	if err != nil {
+		return "", fmt.Errorf("could not build request: %w", err)
+	}
+	policy.SetHeaders(req.Header)
+	req.Header.Set(handlers.CodeSecretHeader, secret)
+	req.Header.Set("User-Agent", s.UserAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not register short code: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("could not read body of response: %w", err)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("unexpected response code from sdp server: [%s] %s", resp.Status, string(body))
+	}
+	return fmt.Sprintf("%s-%s", strings.TrimSpace(string(body)), secret), nil
+}
+
+// resolveCode looks up the invite a short code was given on the server
+func (s *Session) resolveCode(slot int, secret string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, handlers.ShortCodeURL(s.SDPServer, slot), nil)
+	if err != nil {
+		return "", fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set(handlers.CodeSecretHeader, secret)
+	req.Header.Set("User-Agent", s.UserAgent)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve short code with %s: %w", s.SDPServer, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("could not read body of response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("could not resolve short code with %s, check it with your host: %s", s.SDPServer, strings.TrimSpace(string(body)))
+	}
+	u, err := url.Parse(s.SDPServer)
+	if err != nil {
+		return "", fmt.Errorf("badly formed sdp server %s: %w", s.SDPServer, err)
+	}
+	u.Path = strings.TrimSpace(string(body))
+	return u.String(), nil
+}
+
 func (s *Session) cleanup() error {
 	if s.DataChannel != nil {
 		if err := s.DataChannel.SendText("quit"); err != nil {
@@ -172,6 +348,9 @@ func (s *Session) createPeerConnection() error {
 			},
 		},
 	}
+	if s.Certificate != nil {
+		config.Certificates = []webrtc.Certificate{*s.Certificate}
+	}
 	pc, err := webrtc.NewPeerConnection(config)
 	if err != nil {
 		return fmt.Errorf("could not create peer connection with config: %+v: %w", config, err)
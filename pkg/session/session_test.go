@@ -0,0 +1,72 @@
+package session
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPollSDP(t *testing.T) {
+	pollDelay, pollMaxBackoff = time.Millisecond, 4*time.Millisecond
+	defer func() { pollDelay, pollMaxBackoff = time.Second, 30*time.Second }()
+	tests := []struct {
+		name     string
+		statuses []int
+		wantErr  bool
+		polls    int
+	}{
+		{name: "answer", statuses: []int{http.StatusOK}, polls: 1},
+		{name: "no answer yet", statuses: []int{http.StatusNoContent, http.StatusNoContent, http.StatusOK}, polls: 3},
+		{name: "router timeouts and server errors", statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway, http.StatusNoContent, http.StatusOK}, polls: 4},
+		{name: "gone", statuses: []int{http.StatusNoContent, http.StatusGone}, wantErr: true, polls: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			polls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.URL.Query().Get("wait") != "25" {
+					t.Errorf("expected to wait 25s, got %q", r.URL.RawQuery)
+				}
+				status := tt.statuses[polls]
+				polls++
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					_, _ = w.Write([]byte("answer"))
+				}
+			}))
+			defer srv.Close()
+			s := Session{Debug: log.New(ioutil.Discard, "", 0)}
+			body, err := s.pollSDP(srv.URL+"/m/answer", time.Now().Add(5*time.Second))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error, got %q", body)
+				}
+			} else if err != nil || string(body) != "answer" {
+				t.Errorf("expected the answer, got %q %v", body, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if polls != tt.polls {
+				t.Errorf("expected %d polls, got %d", tt.polls, polls)
+			}
+		})
+	}
+	// a server that is down is retried until the deadline
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	s := Session{Debug: log.New(ioutil.Discard, "", 0)}
+	start := time.Now()
+	if _, err := s.pollSDP(srv.URL, start.Add(50*time.Millisecond)); err == nil {
+		t.Errorf("expected the invite to expire")
+	}
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("expected to keep polling until the deadline")
+	}
+}
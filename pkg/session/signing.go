@@ -0,0 +1,101 @@
+package session
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/bottlerocketlabs/pair/pkg/sshkeys"
+	"golang.org/x/crypto/ssh"
+)
+
+var errBadInviteSignature = errors.New("invite is not signed by a key you trust")
+
+// invitePayload is what the host signs, everything in the offer a guest relies on
+func invitePayload(sd SessionDescription) []byte {
+	return []byte(fmt.Sprintf("pair-invite-v1\n%s\n%s\n%s\n%s", sd.Host, sd.SDPURI, sd.SDPAnswerURI, sd.SDP))
+}
+
+// signOffer signs the offer with the host's SigningKey from their ssh-agent, or the first key
+// in it when SigningKey is nil
+func (hs *HostSession) signOffer() error {
+	ag, conn, err := sshkeys.Agent()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	signers, err := sshkeys.AgentSigners(ag)
+	if err != nil {
+		return err
+	}
+	var signer ssh.Signer
+	for _, s := range signers {
+		if hs.SigningKey == nil || bytes.Equal(s.PublicKey().Marshal(), hs.SigningKey.Marshal()) {
+			signer = s
+			break
+		}
+	}
+	if signer == nil {
+		if hs.SigningKey == nil {
+			return errors.New("no keys in ssh-agent")
+		}
+		return fmt.Errorf("key %s is not in ssh-agent", ssh.FingerprintSHA256(hs.SigningKey))
+	}
+	hs.OfferSD.Signature = nil
+	sig, err := sshkeys.Sign(signer, invitePayload(hs.OfferSD))
+	if err != nil {
+		return fmt.Errorf("could not sign with %s: %w", ssh.FingerprintSHA256(signer.PublicKey()), err)
+	}
+	hs.OfferSD.Signature = &authSignature{Key: signer.PublicKey().Marshal(), Format: sig.Format, Blob: sig.Blob}
+	return nil
+}
+
+// verifyInvite checks the offer is signed by a key allowed to sign as the host it names
+func verifyInvite(sd SessionDescription, allowed sshkeys.AllowedSigners) (ssh.PublicKey, error) {
+	if sd.Signature == nil {
+		return nil, fmt.Errorf("%w: it is not signed", errBadInviteSignature)
+	}
+	key, err := ssh.ParsePublicKey(sd.Signature.Key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: could not parse signing key: %s", errBadInviteSignature, err)
+	}
+	unsigned := sd
+	unsigned.Signature = nil
+	if err := sshkeys.Verify(key, invitePayload(unsigned), &ssh.Signature{Format: sd.Signature.Format, Blob: sd.Signature.Blob}); err != nil {
+		return key, fmt.Errorf("%w: bad signature from %s: %s", errBadInviteSignature, ssh.FingerprintSHA256(key), err)
+	}
+	if !allowed.Allows(key, sd.Host) {
+		return key, fmt.Errorf("%w: %s is not allowed to sign as %q", errBadInviteSignature, ssh.FingerprintSHA256(key), sd.Host)
+	}
+	return key, nil
+}
+
+// checkInviteSignature refuses an invite that is not signed by one of the AllowedSigners,
+// or on a terminal lets the guest decide after a warning
+func (cs *ClientSession) checkInviteSignature() error {
+	if cs.AllowedSigners == nil {
+		if cs.OfferSD.Signature != nil {
+			cs.Debug.Printf("invite is signed, but there are no allowed signers to check it against")
+		}
+		return nil
+	}
+	key, err := verifyInvite(cs.OfferSD, cs.AllowedSigners)
+	if err == nil {
+		cs.Debug.Printf("invite signed by %s as %s", ssh.FingerprintSHA256(key), cs.OfferSD.Host)
+		return nil
+	}
+	_, _ = fmt.Fprintf(cs.Stderr, "[pair] WARNING: %s\n[pair] someone other than your teammate may have sent you this invite\n", err)
+	if !cs.IsTerminal {
+		return err
+	}
+	_, _ = fmt.Fprintf(cs.Stderr, "Join anyway? [y/N] ")
+	answer, rerr := readLine(cs.Stdin)
+	if rerr != nil {
+		return fmt.Errorf("could not read answer: %w", rerr)
+	}
+	if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+		return err
+	}
+	return nil
+}
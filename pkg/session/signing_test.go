@@ -0,0 +1,63 @@
+package session
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"github.com/bottlerocketlabs/pair/pkg/sshkeys"
+	"golang.org/x/crypto/ssh"
+)
+
+func signInvite(t *testing.T, signer ssh.Signer, sd SessionDescription) SessionDescription {
+	sig, err := signer.Sign(rand.Reader, invitePayload(sd))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sd.Signature = &authSignature{Key: signer.PublicKey().Marshal(), Format: sig.Format, Blob: sig.Blob}
+	return sd
+}
+
+func TestVerifyInvite(t *testing.T) {
+	alice, eve := newSigner(t), newSigner(t)
+	allowed := sshkeys.AllowedSigners{{Principals: []string{"alice@*"}, Key: alice.PublicKey()}}
+	sd := SessionDescription{
+		SDP:          "v=0\r\na=fingerprint:sha-256 AA\r\n",
+		SDPURI:       "https://pair.example/m/offer",
+		SDPAnswerURI: "https://pair.example/m/answer",
+		Host:         "alice@laptop",
+	}
+
+	signed := signInvite(t, alice, sd)
+	if _, err := verifyInvite(signed, allowed); err != nil {
+		t.Errorf("expected alice's invite to verify: %s", err)
+	}
+	// survives being shared
+	encoded, err := signed.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded SessionDescription
+	if err := decoded.Decode(encoded); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := verifyInvite(decoded, allowed); err != nil {
+		t.Errorf("expected decoded invite to verify: %s", err)
+	}
+
+	if _, err := verifyInvite(sd, allowed); err == nil {
+		t.Errorf("expected an unsigned invite to be refused")
+	}
+	if _, err := verifyInvite(signInvite(t, eve, sd), allowed); err == nil {
+		t.Errorf("expected eve's invite to be refused")
+	}
+	tampered := signed
+	tampered.SDPAnswerURI = "https://evil.example/m/answer"
+	if _, err := verifyInvite(tampered, allowed); err == nil {
+		t.Errorf("expected a tampered invite to be refused")
+	}
+	impersonated := sd
+	impersonated.Host = "bob@desk"
+	if _, err := verifyInvite(signInvite(t, alice, impersonated), allowed); err == nil {
+		t.Errorf("expected alice not to be allowed to sign as bob")
+	}
+}
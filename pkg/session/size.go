@@ -0,0 +1,226 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/kr/pty"
+)
+
+// Size of a terminal in character cells
+type Size struct {
+	Rows uint16
+	Cols uint16
+}
+
+func (s Size) String() string {
+	return fmt.Sprintf("%dx%d", s.Cols, s.Rows)
+}
+
+func (s Size) IsZero() bool {
+	return s.Rows == 0 || s.Cols == 0
+}
+
+// Ways of deciding the size of the shared terminal
+const (
+	SizeSmallest = "smallest"
+	SizeHost     = "host"
+	SizeGuest    = "guest"
+	SizeFixed    = "fixed"
+)
+
+// SizePolicy decides the size everyone sees the shared terminal at
+type SizePolicy struct {
+	Mode  string
+	Fixed Size
+}
+
+// ParseSizePolicy parses one of smallest, host, guest or a fixed size such as 120x40
+func ParseSizePolicy(s string) (SizePolicy, error) {
+	switch s {
+	case SizeSmallest, SizeHost, SizeGuest:
+		return SizePolicy{Mode: s}, nil
+	}
+	parts := strings.SplitN(s, "x", 2)
+	if len(parts) == 2 {
+		cols, cerr := strconv.ParseUint(parts[0], 10, 16)
+		rows, rerr := strconv.ParseUint(parts[1], 10, 16)
+		if cerr == nil && rerr == nil && cols > 0 && rows > 0 {
+			return SizePolicy{Mode: SizeFixed, Fixed: Size{Rows: uint16(rows), Cols: uint16(cols)}}, nil
+		}
+	}
+	return SizePolicy{}, fmt.Errorf("unknown size policy %q, expected smallest, host, guest or COLSxROWS", s)
+}
+
+func (p SizePolicy) String() string {
+	if p.Mode == SizeFixed {
+		return p.Fixed.String()
+	}
+	return p.Mode
+}
+
+// Effective is the size the shared terminal should be given the host and guest sizes,
+// either may be zero when not yet known
+func (p SizePolicy) Effective(host, guest Size) Size {
+	switch p.Mode {
+	case SizeFixed:
+		return p.Fixed
+	case SizeHost:
+		if !host.IsZero() {
+			return host
+		}
+	case SizeGuest:
+		if !guest.IsZero() {
+			return guest
+		}
+	}
+	if host.IsZero() {
+		return guest
+	}
+	if guest.IsZero() {
+		return host
+	}
+	eff := guest
+	if host.Rows < eff.Rows {
+		eff.Rows = host.Rows
+	}
+	if host.Cols < eff.Cols {
+		eff.Cols = host.Cols
+	}
+	return eff
+}
+
+// letterbox draws a border around the agreed size when the local terminal is larger,
+// so the guest can see where the shared terminal ends
+func letterbox(agreed, local Size) string {
+	if agreed.IsZero() || (local.Rows <= agreed.Rows && local.Cols <= agreed.Cols) {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("\x1b7\x1b[2m")
+	if local.Cols > agreed.Cols {
+		for row := uint16(1); row <= agreed.Rows && row <= local.Rows; row++ {
+			fmt.Fprintf(&b, "\x1b[%d;%dH│\x1b[K", row, agreed.Cols+1)
+		}
+	}
+	if local.Rows > agreed.Rows {
+		fmt.Fprintf(&b, "\x1b[%d;1H", agreed.Rows+1)
+		cols := agreed.Cols
+		if cols > local.Cols {
+			cols = local.Cols
+		}
+		b.WriteString(strings.Repeat("─", int(cols)))
+		if local.Cols > agreed.Cols {
+			b.WriteString("┘")
+		}
+		b.WriteString("\x1b[K\x1b[J")
+	}
+	b.WriteString("\x1b[0m\x1b8")
+	return b.String()
+}
+
+// hostSize is the size of the host's tmux client, zero if it cannot be found
+func (hs *HostSession) hostSize() Size {
+	if hs.HostState.Client == "" {
+		return Size{}
+	}
+	out, err := hs.runHost("display-message", "-p", "-c", hs.HostState.Client, "#{client_height} #{client_width}")
+	if err != nil {
+		return Size{}
+	}
+	var size Size
+	if _, err := fmt.Sscan(out, &size.Rows, &size.Cols); err != nil {
+		return Size{}
+	}
+	return size
+}
+
+// watchHostSize reapplies the size policy as the host's terminal changes size,
+// a client-resized hook wakes it up so tmux is not asked for the size until it changes
+func (hs *HostSession) watchHostSize(done <-chan struct{}) {
+	channel := fmt.Sprintf("pair-size-%d", os.Getpid())
+	err := hs.watchHook(hs.Tmux, "client-resized", channel, done, func() error {
+		if err := hs.applySize(); err != nil {
+			return fmt.Errorf("could not apply size policy: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		hs.Debug.Printf("not following host size: %s", err)
+	}
+}
+
+// applySize resizes the shared terminal according to the size policy and tells everyone the result
+func (hs *HostSession) applySize() error {
+	host := hs.hostSize()
+	hs.sizeMu.Lock()
+	guest := Size{Rows: hs.guestSize.Rows, Cols: hs.guestSize.Cols}
+	eff := hs.SizePolicy.Effective(host, guest)
+	if eff.IsZero() || eff == hs.size {
+		hs.sizeMu.Unlock()
+		return nil
+	}
+	ws := pty.Winsize{Rows: eff.Rows, Cols: eff.Cols}
+	if eff == guest {
+		ws.X, ws.Y = hs.guestSize.X, hs.guestSize.Y
+	}
+	hs.Debug.Printf("changing size of terminal %+v\n", ws)
+	if err := pty.Setsize(hs.Pty, &ws); err != nil {
+		hs.sizeMu.Unlock()
+		return err
+	}
+	hs.size = eff
+	hs.sizeMu.Unlock()
+	hs.sizeTmuxWindows(eff)
+	if hs.DataChannel != nil {
+		if err := hs.DataChannel.SendText(fmt.Sprintf(`["size",%d,%d]`, eff.Rows, eff.Cols)); err != nil {
+			return fmt.Errorf("could not send size to guest: %w", err)
+		}
+	}
+	hs.tellHost(fmt.Sprintf("pair: shared at %s (%s)", eff, hs.SizePolicy))
+	return nil
+}
+
+// sizeTmuxWindows stops tmux sizing the shared windows to the smallest client when
+// a larger size has been agreed, so clients smaller than it see part of the window
+func (hs *HostSession) sizeTmuxWindows(eff Size) {
+	srv := hs.sharedTmux()
+	hs.tmuxMu.Lock()
+	session := hs.TmuxSession
+	hs.tmuxMu.Unlock()
+	mode := "manual"
+	if hs.SizePolicy.Mode == SizeSmallest || hs.SizePolicy.Mode == "" {
+		mode = "smallest"
+	}
+	windows, err := srv.Windows(session)
+	if err != nil {
+		hs.Debug.Printf("could not list windows to resize: %s", err)
+		return
+	}
+	for _, w := range windows {
+		if _, err := srv.Run("set-option", "-w", "-t", w.ID, "window-size", mode); err != nil {
+			hs.Debug.Printf("could not set window size of %s: %s", w.ID, err)
+			continue
+		}
+		if mode != "manual" {
+			continue
+		}
+		if _, err := srv.Run("resize-window", "-t", w.ID, "-x", fmt.Sprint(eff.Cols), "-y", fmt.Sprint(eff.Rows)); err != nil {
+			hs.Debug.Printf("could not resize window %s: %s", w.ID, err)
+		}
+	}
+}
+
+// unsizeTmuxWindows hands sizing of the shared windows back to tmux
+func (hs *HostSession) unsizeTmuxWindows(session string) {
+	srv := hs.sharedTmux()
+	windows, err := srv.Windows(session)
+	if err != nil {
+		return
+	}
+	for _, w := range windows {
+		_, _ = srv.Run("set-option", "-w", "-u", "-t", w.ID, "window-size")
+	}
+}
@@ -0,0 +1,60 @@
+package session
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseSizePolicy(t *testing.T) {
+	p, err := ParseSizePolicy("120x40")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if p.Mode != SizeFixed || p.Fixed != (Size{Rows: 40, Cols: 120}) {
+		t.Errorf("unexpected policy: %+v", p)
+	}
+	for _, bad := range []string{"", "biggest", "0x40", "120x", "x"} {
+		if _, err := ParseSizePolicy(bad); err == nil {
+			t.Errorf("expected error parsing %q", bad)
+		}
+	}
+}
+
+func TestEffectiveSize(t *testing.T) {
+	host := Size{Rows: 50, Cols: 100}
+	guest := Size{Rows: 40, Cols: 200}
+	tests := []struct {
+		policy   string
+		host     Size
+		expected Size
+	}{
+		{SizeSmallest, host, Size{Rows: 40, Cols: 100}},
+		{SizeHost, host, host},
+		{SizeGuest, host, guest},
+		{"80x24", host, Size{Rows: 24, Cols: 80}},
+		{SizeHost, Size{}, guest},
+		{SizeSmallest, Size{}, guest},
+	}
+	for _, tt := range tests {
+		p, err := ParseSizePolicy(tt.policy)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if eff := p.Effective(tt.host, guest); eff != tt.expected {
+			t.Errorf("%s with host %s: got %s, expected %s", tt.policy, tt.host, eff, tt.expected)
+		}
+	}
+}
+
+func TestLetterbox(t *testing.T) {
+	agreed := Size{Rows: 2, Cols: 3}
+	if box := letterbox(agreed, agreed); box != "" {
+		t.Errorf("should not letterbox the same size: %q", box)
+	}
+	box := letterbox(agreed, Size{Rows: 3, Cols: 4})
+	for _, expected := range []string{"\x1b[1;4H│", "\x1b[2;4H│", "\x1b[3;1H───┘"} {
+		if !strings.Contains(box, expected) {
+			t.Errorf("expected %q in %q", expected, box)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"unicode/utf8"
+)
+
+// snapshotChunk is the most bytes of a snapshot sent in one message, well within the
+// data channel's message size once encoded as JSON
+const snapshotChunk = 16 * 1024
+
+// chunkLines splits b into pieces of at most max bytes, breaking after a newline where
+// possible and never inside a UTF-8 character
+func chunkLines(b []byte, max int) [][]byte {
+	var chunks [][]byte
+	for len(b) > max {
+		n := bytes.LastIndexByte(b[:max], '\n') + 1
+		if n == 0 {
+			n = max
+			for n > 0 && !utf8.RuneStart(b[n]) {
+				n--
+			}
+			if n == 0 {
+				n = max
+			}
+		}
+		chunks = append(chunks, b[:n])
+		b = b[n:]
+	}
+	if len(b) > 0 {
+		chunks = append(chunks, b)
+	}
+	return chunks
+}
+
+// sendSnapshot sends the guest the screen and scrollback of the pane they are about to see,
+// before the pty starts so it lands in their terminal's own scrollback
+func (hs *HostSession) sendSnapshot() error {
+	if hs.Scrollback < 0 {
+		return nil
+	}
+	target := hs.GuestWindow()
+	if target == "" {
+		target = hs.TmuxSession
+	}
+	b, err := hs.sharedTmux().CapturePane(target, hs.Scrollback)
+	if err != nil {
+		return err
+	}
+	b = bytes.TrimRight(b, "\n")
+	for _, chunk := range chunkLines(b, snapshotChunk) {
+		msg, err := json.Marshal([]string{"snapshot", string(chunk)})
+		if err != nil {
+			return fmt.Errorf("could not marshal snapshot: %w", err)
+		}
+		if err := hs.DataChannel.SendText(string(msg)); err != nil {
+			return fmt.Errorf("could not send snapshot: %w", err)
+		}
+	}
+	if err := hs.DataChannel.SendText(`["snapshot_end"]`); err != nil {
+		return fmt.Errorf("could not send end of snapshot: %w", err)
+	}
+	hs.Debug.Printf("sent snapshot of %s, %d bytes", target, len(b))
+	return nil
+}
+
+// writeSnapshot prints part of the snapshot sent by the host and keeps a copy of it
+func (cs *ClientSession) writeSnapshot(chunk string) error {
+	if cs.snapshot == nil {
+		f, err := ioutil.TempFile("", "pair-snapshot-*.txt")
+		if err != nil {
+			return fmt.Errorf("could not create snapshot file: %w", err)
+		}
+		cs.snapshot = f
+	}
+	if _, err := cs.snapshot.WriteString(chunk); err != nil {
+		return fmt.Errorf("could not save snapshot: %w", err)
+	}
+	// the terminal may already be raw, so newlines need a carriage return too
+	out := bytes.ReplaceAll([]byte(chunk), []byte("\n"), []byte("\r\n"))
+	if _, err := cs.Stdout.Write(out); err != nil {
+		return fmt.Errorf("could not print snapshot: %w", err)
+	}
+	return nil
+}
+
+// endSnapshot finishes the snapshot, telling the guest where the copy of it is
+func (cs *ClientSession) endSnapshot() error {
+	if cs.snapshot == nil {
+		return nil
+	}
+	name := cs.snapshot.Name()
+	if _, err := cs.snapshot.WriteString("\n"); err != nil {
+		return fmt.Errorf("could not save snapshot: %w", err)
+	}
+	if err := cs.snapshot.Close(); err != nil {
+		return fmt.Errorf("could not save snapshot: %w", err)
+	}
+	cs.snapshot = nil
+	_, err := fmt.Fprintf(cs.Stdout, "\x1b[0m\r\n[pair] history before you joined is in your scrollback and saved to %s, view it with less -R\r\n", name)
+	return err
+}
@@ -0,0 +1,25 @@
+package session
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestChunkLines(t *testing.T) {
+	b := []byte("one\ntwo\nthree\n")
+	chunks := chunkLines(b, 9)
+	if len(chunks) != 2 || string(chunks[0]) != "one\ntwo\n" || string(chunks[1]) != "three\n" {
+		t.Errorf("unexpected chunks: %q", chunks)
+	}
+	// a long line is split between characters rather than inside one
+	long := bytes.Repeat([]byte("é"), 10)
+	chunks = chunkLines(long, 5)
+	if !bytes.Equal(bytes.Join(chunks, nil), long) {
+		t.Errorf("chunks do not join back together: %q", chunks)
+	}
+	for _, c := range chunks {
+		if len(c) > 5 || len(c)%2 != 0 {
+			t.Errorf("chunk splits a character: %q", c)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/bottlerocketlabs/pair/pkg/random"
+	"github.com/bottlerocketlabs/pair/pkg/tmux"
+)
+
+// followKey toggles whether a guest in a grouped session follows the host's window
+const followKey = "F"
+
+// sharedTmux is the server TmuxSession lives on
+func (hs *HostSession) sharedTmux() tmux.Server {
+	if hs.IsolatedServer != nil {
+		return *hs.IsolatedServer
+	}
+	return hs.Tmux
+}
+
+// GuestWindow is the window the guest currently sees
+func (hs *HostSession) GuestWindow() string {
+	hs.tmuxMu.Lock()
+	defer hs.tmuxMu.Unlock()
+	if hs.guestSession != "" {
+		return hs.guestWindow
+	}
+	return hs.hostWindow
+}
+
+// watchTmux attaches a control mode client to the shared session to follow changes to it
+func (hs *HostSession) watchTmux() error {
+	c, err := hs.sharedTmux().Control(hs.TmuxSession)
+	if err != nil {
+		return err
+	}
+	lines, err := c.Command("display-message", "-p", "-t", hs.TmuxSession, "#{session_id} #{window_id}")
+	if err != nil || len(lines) != 1 {
+		_ = c.Close()
+		return fmt.Errorf("could not get shared session: %q: %w", lines, err)
+	}
+	fields := strings.Fields(lines[0])
+	if len(fields) != 2 {
+		_ = c.Close()
+		return fmt.Errorf("unexpected shared session: %q", lines[0])
+	}
+	hs.control = c
+	hs.sessionID, hs.hostWindow = fields[0], fields[1]
+	go hs.handleTmuxEvents(c.Events())
+	if hs.Grouped {
+		channel := fmt.Sprintf("pair-follow-%d", os.Getpid())
+		err := hs.bindKey(hs.sharedTmux(), followKey, "if-shell", "-F", "#{@pair-follow}",
+			"set-option @pair-follow 0 ; display-message 'pair: not following host'",
+			"set-option @pair-follow 1 ; display-message 'pair: following host' ; wait-for -S "+channel)
+		if err != nil {
+			hs.Debug.Printf("could not bind follow toggle: %s", err)
+		}
+		go hs.followHost(channel, c.Done())
+	}
+	return nil
+}
+
+func (hs *HostSession) handleTmuxEvents(events <-chan tmux.Event) {
+	for ev := range events {
+		hs.Debug.Printf("tmux event: %s %q", ev.Name, ev.Args)
+		hostMoved, windowAdded := false, false
+		hs.tmuxMu.Lock()
+		switch ev.Name {
+		case tmux.EventWindowPaneChanged, tmux.EventLayoutChange, tmux.EventWindowClose, tmux.EventUnlinkedWindowClose:
+			hs.forgetGuestTTY()
+		}
+		switch ev.Name {
+		case tmux.EventWindowAdd:
+			windowAdded = true
+		case tmux.EventSessionWindowChanged:
+			if len(ev.Args) != 2 {
+				break
+			}
+			switch ev.Args[0] {
+			case hs.sessionID:
+				hs.hostWindow = ev.Args[1]
+				hostMoved = true
+			case hs.guestSessionID:
+				hs.guestWindow = ev.Args[1]
+			}
+			hs.forgetGuestTTY()
+		case tmux.EventSessionRenamed:
+			if len(ev.Args) == 2 && ev.Args[0] == hs.sessionID {
+				hs.TmuxSession = ev.Args[1]
+			}
+		}
+		hs.tmuxMu.Unlock()
+		if hostMoved {
+			hs.syncGuestWindow()
+		}
+		if windowAdded {
+			hs.sizeMu.Lock()
+			size := hs.size
+			hs.sizeMu.Unlock()
+			if !size.IsZero() {
+				hs.sizeTmuxWindows(size)
+			}
+		}
+	}
+}
+
+// startGuestSession creates a session grouped with the shared one for the guest to attach to
+// and returns the command to attach to it
+func (hs *HostSession) startGuestSession() ([]string, error) {
+	srv := hs.sharedTmux()
+	suffix, err := random.String(3)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("%s-guest-%s", hs.TmuxSession, suffix)
+	id, err := srv.NewGroupedSession(hs.TmuxSession, name)
+	if err != nil {
+		return nil, err
+	}
+	follow := "0"
+	if hs.Follow {
+		follow = "1"
+	}
+	if _, err := srv.Run("set-option", "-t", name, "@pair-follow", follow); err != nil {
+		_ = srv.KillSession(name)
+		return nil, fmt.Errorf("could not set follow option on guest session: %w", err)
+	}
+	if hs.floor != nil {
+		if err := hs.showDriverStatus(name); err != nil {
+			hs.Debug.Printf("could not show driver to guest: %s", err)
+		}
+	}
+	if hs.hooksDir != "" {
+		if err := hs.setCoauthorEnv(name); err != nil {
+			hs.Debug.Printf("not crediting guest in commits: %s", err)
+		}
+	}
+	window, err := srv.Display(name, "#{window_id}")
+	if err != nil {
+		_ = srv.KillSession(name)
+		return nil, fmt.Errorf("could not get window of guest session: %w", err)
+	}
+	hs.tmuxMu.Lock()
+	hs.guestSession, hs.guestSessionID, hs.guestWindow = name, id, window
+	hs.tmuxMu.Unlock()
+	hs.Debug.Printf("guest session: %s (%s)", name, id)
+	hs.syncGuestWindow()
+	return srv.AttachCommand(name), nil
+}
+
+// stopGuestSession removes the guest's grouped session, if they have one
+func (hs *HostSession) stopGuestSession() error {
+	hs.tmuxMu.Lock()
+	defer hs.tmuxMu.Unlock()
+	if hs.guestSession == "" {
+		return nil
+	}
+	hs.Debug.Printf("removing guest session %s", hs.guestSession)
+	if err := hs.sharedTmux().KillSession(hs.guestSession); err != nil {
+		return err
+	}
+	hs.guestSession, hs.guestSessionID, hs.guestWindow = "", "", ""
+	return nil
+}
+
+// followHost resyncs the guest's window when the follow toggle signals channel, so turning
+// follow on takes effect without waiting for the host to change window
+func (hs *HostSession) followHost(channel string, done <-chan struct{}) {
+	hs.waitFor(hs.sharedTmux(), channel, done, func() error {
+		hs.syncGuestWindow()
+		return nil
+	})
+}
+
+// waitFor calls fn each time channel is signalled on srv until done is closed or fn fails,
+// tmux holds the waiting command open so nothing is polled in between
+func (hs *HostSession) waitFor(srv tmux.Server, channel string, done <-chan struct{}, fn func() error) {
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-done:
+			_ = srv.Signal(channel)
+		case <-stopped:
+		}
+	}()
+	for {
+		if err := srv.WaitFor(channel); err != nil {
+			hs.Debug.Printf("%s", err)
+			return
+		}
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if err := fn(); err != nil {
+			hs.Debug.Printf("%s", err)
+			return
+		}
+	}
+}
+
+// syncGuestWindow moves the guest to the host's window if they are following the host
+func (hs *HostSession) syncGuestWindow() {
+	hs.tmuxMu.Lock()
+	session, hostWindow, guestWindow := hs.guestSession, hs.hostWindow, hs.guestWindow
+	hs.tmuxMu.Unlock()
+	if session == "" || hs.control == nil || hostWindow == guestWindow {
+		return
+	}
+	lines, err := hs.control.Command("show-options", "-v", "-q", "-t", session, "@pair-follow")
+	if err != nil || len(lines) == 0 || lines[0] != "1" {
+		return
+	}
+	if _, err := hs.control.Command("select-window", "-t", session+":"+hostWindow); err != nil {
+		hs.Debug.Printf("could not move guest to host window: %s", err)
+	}
+}
+
+func (hs *HostSession) restoreTmux() error {
+	hs.removeHooks()
+	if err := hs.stopGuestSession(); err != nil {
+		return err
+	}
+	hs.sizeMu.Lock()
+	sized := !hs.size.IsZero()
+	hs.sizeMu.Unlock()
+	hs.tmuxMu.Lock()
+	defer hs.tmuxMu.Unlock()
+	if hs.HostState.Client != "" {
+		hs.Debug.Printf("restoring tmux client %+v", hs.HostState)
+		if err := hs.Tmux.RestoreClientState(hs.HostState); err != nil {
+			return err
+		}
+	}
+	if sized {
+		hs.unsizeTmuxWindows(hs.TmuxSession)
+	}
+	hs.unbindKeys()
+	if hs.IsolatedServer != nil {
+		hs.Debug.Printf("stopping isolated tmux server %s", hs.IsolatedServer.SocketPath)
+		return hs.IsolatedServer.Kill()
+	}
+	if hs.KillSessionOnExit {
+		hs.Debug.Printf("removing tmux session %s", hs.TmuxSession)
+		if err := hs.Tmux.KillSession(hs.TmuxSession); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installedHook is a hook pair added on srv to signal channel
+type installedHook struct {
+	srv     tmux.Server
+	name    string
+	channel string
+}
+
+// staleHook matches the commands of hooks added by watchHook, with the pid of the pair that added them
+var staleHook = regexp.MustCompile(`^wait-for -S pair-[a-z]+-([0-9]+)$`)
+
+// watchHook runs fn each time hook runs on srv, until done. The hook is added the first
+// time it is watched and stays until removeHooks, which restoreTmux calls on the way out.
+func (hs *HostSession) watchHook(srv tmux.Server, hook, channel string, done <-chan struct{}, fn func() error) error {
+	hs.hooksMu.Lock()
+	if hs.hooksRemoved {
+		hs.hooksMu.Unlock()
+		return fmt.Errorf("could not add %s hook: pair is exiting", hook)
+	}
+	added := false
+	for _, h := range hs.hooks {
+		if h.channel == channel && h.srv.SocketName == srv.SocketName && h.srv.SocketPath == srv.SocketPath {
+			added = true
+		}
+	}
+	if !added {
+		name, err := srv.AddHook(hook, "wait-for -S "+channel)
+		if err != nil {
+			hs.hooksMu.Unlock()
+			return err
+		}
+		hs.hooks = append(hs.hooks, installedHook{srv: srv, name: name, channel: channel})
+	}
+	hs.hooksMu.Unlock()
+	hs.waitFor(srv, channel, done, fn)
+	return nil
+}
+
+// removeHooks removes the hooks added by watchHook, none are added after it
+func (hs *HostSession) removeHooks() {
+	hs.hooksMu.Lock()
+	defer hs.hooksMu.Unlock()
+	hs.hooksRemoved = true
+	for i := len(hs.hooks) - 1; i >= 0; i-- {
+		if err := hs.hooks[i].srv.RemoveHook(hs.hooks[i].name); err != nil {
+			hs.Debug.Printf("%s", err)
+		}
+	}
+	hs.hooks = nil
+}
+
+// removeStaleHooks removes hooks left on srv by a pair that did not get to remove them,
+// such as one that was killed
+func (hs *HostSession) removeStaleHooks(srv tmux.Server) {
+	hooks, err := srv.Hooks()
+	if err != nil {
+		hs.Debug.Printf("not removing stale hooks: %s", err)
+		return
+	}
+	var stale []string
+	for name, cmd := range hooks {
+		m := staleHook.FindStringSubmatch(cmd)
+		if m == nil {
+			continue
+		}
+		pid, err := strconv.Atoi(m[1])
+		if err != nil || pid == os.Getpid() || syscall.Kill(pid, 0) != syscall.ESRCH {
+			continue
+		}
+		stale = append(stale, name)
+	}
+	for _, name := range stale {
+		hs.Debug.Printf("removing stale hook %s", name)
+		if err := srv.RemoveHook(name); err != nil {
+			hs.Debug.Printf("%s", err)
+		}
+	}
+}
+
+// savedBinding is a key pair rebound on srv
+type savedBinding struct {
+	srv     tmux.Server
+	binding tmux.Binding
+}
+
+// bindKey binds key in the prefix table of srv to cmd, keeping what it was bound to
+// before for unbindKeys
+func (hs *HostSession) bindKey(srv tmux.Server, key string, cmd ...string) error {
+	b, err := srv.SaveBinding("prefix", key)
+	if err != nil {
+		return err
+	}
+	if _, err := srv.Run(append([]string{"bind-key", key}, cmd...)...); err != nil {
+		return fmt.Errorf("could not bind %s: %w", key, err)
+	}
+	hs.savedBindings = append(hs.savedBindings, savedBinding{srv: srv, binding: b})
+	return nil
+}
+
+// unbindKeys puts back the keys changed by bindKey, latest first
+func (hs *HostSession) unbindKeys() {
+	for i := len(hs.savedBindings) - 1; i >= 0; i-- {
+		saved := hs.savedBindings[i]
+		if err := saved.srv.RestoreBinding(saved.binding); err != nil {
+			hs.Debug.Printf("%s", err)
+		}
+	}
+	hs.savedBindings = nil
+}
+
+// redrawGuest redraws the whole of the guest's terminal, their tmux client is the one attached from our pty
+func (hs *HostSession) redrawGuest() {
+	client, ok := hs.guestClient()
+	if !ok {
+		return
+	}
+	if _, err := hs.sharedTmux().Run("refresh-client", "-t", client); err != nil {
+		hs.Debug.Printf("could not redraw guest client: %s", err)
+	}
+}
+
+// guestClient is the name of the tmux client the guest is attached with,
+// it is looked up once through the control client and kept until the guest leaves
+func (hs *HostSession) guestClient() (string, bool) {
+	hs.tmuxMu.Lock()
+	client, cmd := hs.guestClientName, hs.guestCmd
+	hs.tmuxMu.Unlock()
+	if client != "" {
+		return client, true
+	}
+	if cmd == nil || hs.control == nil {
+		return "", false
+	}
+	lines, err := hs.control.Command("list-clients", "-F", "#{client_pid} #{client_name}")
+	if err != nil {
+		hs.Debug.Printf("could not find guest client: %s", err)
+		return "", false
+	}
+	pid := strconv.Itoa(cmd.Process.Pid)
+	for _, line := range lines {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) == 2 && fields[0] == pid {
+			hs.tmuxMu.Lock()
+			hs.guestClientName = fields[1]
+			hs.tmuxMu.Unlock()
+			return fields[1], true
+		}
+	}
+	return "", false
+}
+
+// tellGuest shows msg in the guest's tmux client
+func (hs *HostSession) tellGuest(msg string) {
+	client, ok := hs.guestClient()
+	if !ok {
+		return
+	}
+	if _, err := hs.sharedTmux().Run("display-message", "-c", client, msg); err != nil {
+		hs.Debug.Printf("could not show message to guest: %s", err)
+	}
+}
+
+// tellHost shows msg in the host's tmux client
+func (hs *HostSession) tellHost(msg string) {
+	if hs.HostState.Client == "" {
+		return
+	}
+	if _, err := hs.runHost("display-message", "-c", hs.HostState.Client, msg); err != nil {
+		hs.Debug.Printf("could not show message to host: %s", err)
+	}
+}
+
+// runHost runs a tmux command on the server the host's client is attached to,
+// through the control client when that is on the same server
+func (hs *HostSession) runHost(args ...string) (string, error) {
+	if hs.IsolatedServer == nil && hs.control != nil {
+		lines, err := hs.control.Command(args...)
+		return strings.Join(lines, "\n"), err
+	}
+	b, err := hs.Tmux.Run(args...)
+	return strings.TrimSuffix(string(b), "\n"), err
+}
@@ -0,0 +1,256 @@
+package sshkeys
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoAgent is returned when there is no ssh-agent to sign with
+var ErrNoAgent = errors.New("no ssh-agent running, SSH_AUTH_SOCK is not set")
+
+// ErrSHA1Signature is returned for ssh-rsa signatures, which use SHA-1
+var ErrSHA1Signature = errors.New("ssh-rsa signatures use SHA-1 and are not accepted")
+
+// AuthorizedKey is a key from an authorized_keys file, Name is its comment
+type AuthorizedKey struct {
+	Key  ssh.PublicKey
+	Name string
+}
+
+// AuthorizedKeys is a set of keys that are allowed in
+type AuthorizedKeys []AuthorizedKey
+
+// DefaultAuthorizedKeys is ~/.ssh/authorized_keys
+func DefaultAuthorizedKeys() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find home directory: %w", err)
+	}
+	return filepath.Join(home, ".ssh", "authorized_keys"), nil
+}
+
+// LoadAuthorizedKeys reads an authorized_keys file, options on each key are ignored
+func LoadAuthorizedKeys(path string) (AuthorizedKeys, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read authorized keys: %w", err)
+	}
+	keys, err := ParseAuthorizedKeys(b)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys in %s", path)
+	}
+	return keys, nil
+}
+
+// ParseAuthorizedKeys parses the keys in the authorized_keys format, a key without a
+// comment is named by its fingerprint
+func ParseAuthorizedKeys(b []byte) (AuthorizedKeys, error) {
+	var keys AuthorizedKeys
+	for len(bytes.TrimSpace(b)) > 0 {
+		key, comment, _, rest, err := ssh.ParseAuthorizedKey(b)
+		if err != nil {
+			return nil, err
+		}
+		if comment == "" {
+			comment = ssh.FingerprintSHA256(key)
+		}
+		keys = append(keys, AuthorizedKey{Key: key, Name: comment})
+		b = rest
+	}
+	return keys, nil
+}
+
+// Find returns the authorized key matching key
+func (keys AuthorizedKeys) Find(key ssh.PublicKey) (AuthorizedKey, bool) {
+	marshaled := key.Marshal()
+	for _, k := range keys {
+		if bytes.Equal(k.Key.Marshal(), marshaled) {
+			return k, true
+		}
+	}
+	return AuthorizedKey{}, false
+}
+
+// LoadPublicKey reads the first key in a public key file such as ~/.ssh/id_ed25519.pub
+func LoadPublicKey(path string) (ssh.PublicKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read public key: %w", err)
+	}
+	key, _, _, _, err := ssh.ParseAuthorizedKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// AllowedSigner is a key from an allowed_signers file and the principals it can sign as
+type AllowedSigner struct {
+	Principals []string
+	Key        ssh.PublicKey
+}
+
+// AllowedSigners is a set of keys trusted to sign for the principals they are listed with
+type AllowedSigners []AllowedSigner
+
+// LoadAllowedSigners reads an allowed_signers file, as used by ssh-keygen -Y verify
+func LoadAllowedSigners(path string) (AllowedSigners, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read allowed signers: %w", err)
+	}
+	signers, err := ParseAllowedSigners(b, namespace)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	return signers, nil
+}
+
+// namespace is what pair signs for, keys restricted to other namespaces are not trusted by pair
+const namespace = "pair"
+
+// ParseAllowedSigners parses lines of comma separated principals followed by a key in the
+// authorized_keys format. Keys with a namespaces option that does not include ns are skipped.
+func ParseAllowedSigners(b []byte, ns string) (AllowedSigners, error) {
+	var signers AllowedSigners
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d has no key", i+1)
+		}
+		key, _, options, _, err := ssh.ParseAuthorizedKey([]byte(fields[1]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if !inNamespace(options, ns) {
+			continue
+		}
+		signers = append(signers, AllowedSigner{Principals: strings.Split(fields[0], ","), Key: key})
+	}
+	return signers, nil
+}
+
+func inNamespace(options []string, ns string) bool {
+	for _, option := range options {
+		if !strings.HasPrefix(strings.ToLower(option), "namespaces=") {
+			continue
+		}
+		for _, n := range strings.Split(strings.Trim(option[len("namespaces="):], `"`), ",") {
+			if n == ns {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// Allows reports whether key is trusted to sign as principal, principals can use * and ? wildcards
+func (signers AllowedSigners) Allows(key ssh.PublicKey, principal string) bool {
+	marshaled := key.Marshal()
+	for _, s := range signers {
+		if !bytes.Equal(s.Key.Marshal(), marshaled) {
+			continue
+		}
+		for _, pattern := range s.Principals {
+			if ok, _ := path.Match(pattern, principal); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Agent connects to the ssh-agent at SSH_AUTH_SOCK, close the connection when done with it
+func Agent() (agent.ExtendedAgent, net.Conn, error) {
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil, nil, ErrNoAgent
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not connect to ssh-agent: %w", err)
+	}
+	return agent.NewClient(conn), conn, nil
+}
+
+// agentSigner signs with a key in an ssh-agent, asking it for SHA-2 signatures from RSA keys
+type agentSigner struct {
+	agent agent.ExtendedAgent
+	key   ssh.PublicKey
+}
+
+func (s agentSigner) PublicKey() ssh.PublicKey {
+	return s.key
+}
+
+func (s agentSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	return s.SignWithAlgorithm(rand, data, "")
+}
+
+func (s agentSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	var flags agent.SignatureFlags
+	switch algorithm {
+	case ssh.SigAlgoRSASHA2256:
+		flags = agent.SignatureFlagRsaSha256
+	case ssh.SigAlgoRSASHA2512:
+		flags = agent.SignatureFlagRsaSha512
+	}
+	return s.agent.SignWithFlags(s.key, data, flags)
+}
+
+// AgentSigners returns a signer for each key in ag that can make SHA-2 signatures with RSA keys
+func AgentSigners(ag agent.ExtendedAgent) ([]ssh.Signer, error) {
+	keys, err := ag.List()
+	if err != nil {
+		return nil, fmt.Errorf("could not list keys in ssh-agent: %w", err)
+	}
+	signers := make([]ssh.Signer, 0, len(keys))
+	for _, key := range keys {
+		pub, err := ssh.ParsePublicKey(key.Blob)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse key in ssh-agent: %w", err)
+		}
+		signers = append(signers, agentSigner{agent: ag, key: pub})
+	}
+	return signers, nil
+}
+
+// Sign signs data with signer, RSA keys sign with rsa-sha2-512 rather than SHA-1
+func Sign(signer ssh.Signer, data []byte) (*ssh.Signature, error) {
+	if signer.PublicKey().Type() != ssh.KeyAlgoRSA {
+		return signer.Sign(rand.Reader, data)
+	}
+	as, ok := signer.(ssh.AlgorithmSigner)
+	if !ok {
+		return nil, fmt.Errorf("could not sign with %s: it can only make SHA-1 signatures", ssh.FingerprintSHA256(signer.PublicKey()))
+	}
+	return as.SignWithAlgorithm(rand.Reader, data, ssh.SigAlgoRSASHA2512)
+}
+
+// Verify checks sig is a signature of data by key, refusing SHA-1 signatures from RSA keys
+func Verify(key ssh.PublicKey, data []byte, sig *ssh.Signature) error {
+	if sig.Format == ssh.SigAlgoRSA {
+		return ErrSHA1Signature
+	}
+	return key.Verify(data, sig)
+}
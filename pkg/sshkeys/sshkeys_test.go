@@ -0,0 +1,133 @@
+package sshkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func newKey(t *testing.T) ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestParseAuthorizedKeys(t *testing.T) {
+	alice, bob, eve := newKey(t), newKey(t), newKey(t)
+	file := "# team keys\n" +
+		strings.TrimSpace(string(ssh.MarshalAuthorizedKey(alice))) + " alice@laptop\n\n" +
+		`no-pty,from="10.0.0.1" ` + string(ssh.MarshalAuthorizedKey(bob))
+	keys, err := ParseAuthorizedKeys([]byte(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+	if k, ok := keys.Find(alice); !ok || k.Name != "alice@laptop" {
+		t.Errorf("expected alice to be named by her comment: %+v %v", k, ok)
+	}
+	if k, ok := keys.Find(bob); !ok || k.Name != ssh.FingerprintSHA256(bob) {
+		t.Errorf("expected bob to be named by his fingerprint: %+v %v", k, ok)
+	}
+	if _, ok := keys.Find(eve); ok {
+		t.Errorf("expected eve not to be found")
+	}
+}
+
+func TestParseAllowedSigners(t *testing.T) {
+	alice, bob, eve := newKey(t), newKey(t), newKey(t)
+	line := func(key ssh.PublicKey) string {
+		return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key)))
+	}
+	file := "# team\n" +
+		"alice@*,al@laptop " + line(alice) + " alice's laptop\n" +
+		`bob@desk namespaces="git,pair" ` + line(bob) + "\n" +
+		`eve@desk namespaces="git" ` + line(eve) + "\n"
+	signers, err := ParseAllowedSigners([]byte(file), "pair")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 {
+		t.Fatalf("expected eve's key for another namespace to be skipped, got %d signers", len(signers))
+	}
+	for _, tc := range []struct {
+		key       ssh.PublicKey
+		principal string
+		allowed   bool
+	}{
+		{alice, "alice@laptop", true},
+		{alice, "al@laptop", true},
+		{alice, "bob@desk", false},
+		{bob, "bob@desk", true},
+		{bob, "bob@laptop", false},
+		{eve, "eve@desk", false},
+	} {
+		if got := signers.Allows(tc.key, tc.principal); got != tc.allowed {
+			t.Errorf("expected %s allowed to be %v", tc.principal, tc.allowed)
+		}
+	}
+	if _, err := ParseAllowedSigners([]byte("alice@laptop\n"), "pair"); err == nil {
+		t.Errorf("expected a line without a key to fail")
+	}
+}
+
+func TestAgentSignersUseSHA2(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ag := agent.NewKeyring().(agent.ExtendedAgent)
+	for _, key := range []interface{}{rsaKey, edKey} {
+		if err := ag.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	signers, err := AgentSigners(ag)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signers) != 2 {
+		t.Fatalf("expected 2 signers, got %d", len(signers))
+	}
+	data := []byte("invite")
+	for _, signer := range signers {
+		sig, err := Sign(signer, data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig.Format == ssh.SigAlgoRSA {
+			t.Errorf("expected a SHA-2 signature from %s", signer.PublicKey().Type())
+		}
+		if err := Verify(signer.PublicKey(), data, sig); err != nil {
+			t.Errorf("expected %s signature to verify: %s", sig.Format, err)
+		}
+	}
+	// a SHA-1 signature from the same key is refused
+	sha1, err := signers[0].Sign(rand.Reader, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sha1.Format != ssh.SigAlgoRSA {
+		t.Fatalf("expected an ssh-rsa signature, got %s", sha1.Format)
+	}
+	if err := Verify(signers[0].PublicKey(), data, sha1); !errors.Is(err, ErrSHA1Signature) {
+		t.Errorf("expected SHA-1 signature to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,255 @@
+package tmux
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Notifications sent to control mode clients, see CONTROL MODE in tmux(1)
+const (
+	EventExit                 = "exit"
+	EventSessionChanged       = "session-changed"
+	EventSessionRenamed       = "session-renamed"
+	EventSessionsChanged      = "sessions-changed"
+	EventSessionWindowChanged = "session-window-changed"
+	EventClientSessionChanged = "client-session-changed"
+	EventClientDetached       = "client-detached"
+	EventWindowAdd            = "window-add"
+	EventWindowClose          = "window-close"
+	EventWindowRenamed        = "window-renamed"
+	EventWindowPaneChanged    = "window-pane-changed"
+	EventLayoutChange         = "layout-change"
+	EventUnlinkedWindowAdd    = "unlinked-window-add"
+	EventUnlinkedWindowClose  = "unlinked-window-close"
+)
+
+// eventFields is how many space separated fields lead each notification,
+// anything after them, such as a name, is kept whole as the last argument
+var eventFields = map[string]int{
+	EventExit:                 0,
+	EventSessionChanged:       1,
+	EventSessionRenamed:       1,
+	EventSessionsChanged:      0,
+	EventSessionWindowChanged: 2,
+	EventClientSessionChanged: 2,
+	EventClientDetached:       1,
+	EventWindowAdd:            1,
+	EventWindowClose:          1,
+	EventWindowRenamed:        1,
+	EventWindowPaneChanged:    2,
+	EventLayoutChange:         3,
+	EventUnlinkedWindowAdd:    1,
+	EventUnlinkedWindowClose:  1,
+}
+
+// Event is a notification from tmux, eg: %window-renamed @1 vim becomes
+// Event{Name: "window-renamed", Args: []string{"@1", "vim"}}
+type Event struct {
+	Name string
+	Args []string
+}
+
+// ErrControlClosed is returned for commands sent after the control client has exited
+var ErrControlClosed = errors.New("tmux control client closed")
+
+type reply struct {
+	lines []string
+	err   error
+}
+
+// Control is a tmux control mode client, it runs commands without starting a process for each
+// and delivers notifications about changes to the server as they happen
+type Control struct {
+	w       io.WriteCloser
+	cmd     *exec.Cmd
+	events  chan Event
+	replies chan reply
+	// pending is how many commands are waiting for a reply, replies nobody waits for are dropped
+	pending int32
+	done    chan struct{}
+	// attached is closed once tmux has attached the client, notifications start after this
+	attached chan struct{}
+	mu       sync.Mutex
+}
+
+// attachTimeout is how long to wait for a control client to attach
+const attachTimeout = 5 * time.Second
+
+// Control attaches a control mode client to session
+func (s Server) Control(session string) (*Control, error) {
+	args := s.Args("-C", "attach-session", "-t", session)
+	cmd := exec.Command(args[0], args[1:]...)
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not get stdin of control client: %w", err)
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("could not get stdout of control client: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start control client: %w", err)
+	}
+	c := NewControl(r, w)
+	c.cmd = cmd
+	// commands sent before the client is attached do not produce notifications
+	select {
+	case <-c.attached:
+	case <-c.done:
+		_ = c.Close()
+		return nil, fmt.Errorf("control client exited before attaching to %s", session)
+	case <-time.After(attachTimeout):
+		_ = c.Close()
+		return nil, fmt.Errorf("control client did not attach to %s", session)
+	}
+	return c, nil
+}
+
+// NewControl speaks the control mode protocol over r and w, use Server.Control to start a client
+func NewControl(r io.Reader, w io.WriteCloser) *Control {
+	c := &Control{
+		w:        w,
+		events:   make(chan Event, 64),
+		replies:  make(chan reply, 1),
+		done:     make(chan struct{}),
+		attached: make(chan struct{}),
+	}
+	go c.read(r)
+	return c
+}
+
+// Events delivers notifications until the client exits, they are dropped if not received promptly
+func (c *Control) Events() <-chan Event {
+	return c.events
+}
+
+// Done is closed when the control client exits
+func (c *Control) Done() <-chan struct{} {
+	return c.done
+}
+
+// Command runs a tmux command and returns its output lines
+func (c *Control) Command(args ...string) ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = quote(a)
+	}
+	atomic.AddInt32(&c.pending, 1)
+	if _, err := io.WriteString(c.w, strings.Join(quoted, " ")+"\n"); err != nil {
+		atomic.AddInt32(&c.pending, -1)
+		return nil, fmt.Errorf("could not send command to control client: %w", err)
+	}
+	select {
+	case r := <-c.replies:
+		if r.err != nil {
+			return r.lines, &CommandError{Args: args, Stderr: strings.Join(r.lines, "\n"), Err: r.err}
+		}
+		return r.lines, nil
+	case <-c.done:
+		return nil, ErrControlClosed
+	}
+}
+
+// Close detaches the control client and waits for it to exit
+func (c *Control) Close() error {
+	err := c.w.Close()
+	<-c.done
+	if c.cmd != nil {
+		_ = c.cmd.Wait()
+	}
+	return err
+}
+
+func (c *Control) read(r io.Reader) {
+	defer close(c.done)
+	defer close(c.events)
+	br := bufio.NewReader(r)
+	var block []string
+	inBlock, ours, attached := false, false, false
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+		if inBlock {
+			switch {
+			case strings.HasPrefix(line, "%end "), strings.HasPrefix(line, "%error "):
+				inBlock = false
+				if !ours || atomic.LoadInt32(&c.pending) == 0 {
+					continue
+				}
+				atomic.AddInt32(&c.pending, -1)
+				r := reply{lines: block}
+				if strings.HasPrefix(line, "%error ") {
+					r.err = errors.New("command failed")
+				}
+				c.replies <- r
+			default:
+				block = append(block, line)
+			}
+			continue
+		}
+		if strings.HasPrefix(line, "%begin ") {
+			// the flags field is 1 for commands sent by this client
+			fields := strings.Fields(line)
+			inBlock, ours, block = true, len(fields) > 3 && fields[3] == "1", nil
+			continue
+		}
+		if ev, ok := parseEvent(line); ok {
+			if ev.Name == EventSessionChanged && !attached {
+				attached = true
+				close(c.attached)
+			}
+			select {
+			case c.events <- ev:
+			default:
+			}
+		}
+	}
+}
+
+// parseEvent turns a notification line into an Event, output from panes is not delivered
+func parseEvent(line string) (Event, bool) {
+	if !strings.HasPrefix(line, "%") {
+		return Event{}, false
+	}
+	parts := strings.SplitN(line[1:], " ", 2)
+	ev := Event{Name: parts[0]}
+	if ev.Name == "output" || ev.Name == "extended-output" {
+		return Event{}, false
+	}
+	if len(parts) == 1 {
+		return ev, true
+	}
+	n, known := eventFields[ev.Name]
+	if !known {
+		ev.Args = strings.Fields(parts[1])
+		return ev, true
+	}
+	ev.Args = strings.SplitN(parts[1], " ", n+1)
+	return ev, true
+}
+
+// quote an argument for the tmux command parser
+func quote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:@%=+,", r))
+	}) == -1 {
+		return s
+	}
+	if !strings.ContainsRune(s, '\'') {
+		return "'" + s + "'"
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, `~`, `\~`)
+	return `"` + r.Replace(s) + `"`
+}
@@ -0,0 +1,47 @@
+package tmux
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	// ErrNoServer is returned when no tmux server is listening on the socket
+	ErrNoServer = errors.New("no tmux server running")
+	// ErrNotFound is returned when the target session, window, pane or client does not exist
+	ErrNotFound = errors.New("tmux target not found")
+	// ErrDuplicateSession is returned when creating a session with a name already in use
+	ErrDuplicateSession = errors.New("tmux session already exists")
+)
+
+// CommandError is returned when a tmux command exits unsuccessfully,
+// use errors.Is with the Err* values to check for common failures
+type CommandError struct {
+	Args   []string
+	Stderr string
+	Err    error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("tmux command failed: %v: [%s] %s", e.Args, e.Stderr, e.Err)
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+func (e *CommandError) Is(target error) bool {
+	switch target {
+	case ErrNoServer:
+		return strings.HasPrefix(e.Stderr, "no server running") ||
+			strings.HasPrefix(e.Stderr, "error connecting to") ||
+			strings.HasPrefix(e.Stderr, "server exited unexpectedly")
+	case ErrNotFound:
+		return strings.HasPrefix(e.Stderr, "can't find") ||
+			strings.HasPrefix(e.Stderr, "session not found")
+	case ErrDuplicateSession:
+		return strings.HasPrefix(e.Stderr, "duplicate session")
+	}
+	return false
+}
@@ -0,0 +1,233 @@
+package tmux
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// separator between fields in format strings, names chosen by users may still contain it
+// so each format puts at most one such field last where it can safely absorb the remainder
+const separator = "\x1f"
+
+type Session struct {
+	ID       string
+	Name     string
+	Windows  int
+	Attached int
+	Grouped  bool
+	Created  time.Time
+}
+
+type Window struct {
+	ID      string
+	Index   int
+	Name    string
+	Active  bool
+	Session string
+	Width   int
+	Height  int
+}
+
+type Pane struct {
+	ID             string
+	Index          int
+	Active         bool
+	PID            int
+	Window         string
+	TTY            string
+	Width          int
+	Height         int
+	CurrentCommand string
+	CurrentPath    string
+}
+
+type Client struct {
+	Name     string
+	TTY      string
+	PID      int
+	Width    int
+	Height   int
+	Termname string
+	ReadOnly bool
+	Session  string
+}
+
+// ClientState records where a tmux client is so it can be put back later
+type ClientState struct {
+	Client  string
+	Session string
+	Window  string
+	Pane    string
+}
+
+func format(fields ...string) string {
+	return strings.Join(fields, separator)
+}
+
+var (
+	sessionFormat     = format("#{session_id}", "#{session_windows}", "#{session_attached}", "#{session_grouped}", "#{session_created}", "#{session_name}")
+	windowFormat      = format("#{window_id}", "#{window_index}", "#{window_active}", "#{window_width}", "#{window_height}", "#{session_name}", "#{window_name}")
+	paneFormat        = format("#{pane_id}", "#{pane_index}", "#{pane_active}", "#{pane_pid}", "#{window_id}", "#{pane_tty}", "#{pane_width}", "#{pane_height}", "#{pane_current_command}", "#{pane_current_path}")
+	clientFormat      = format("#{client_name}", "#{client_tty}", "#{client_pid}", "#{client_width}", "#{client_height}", "#{client_termname}", "#{client_readonly}", "#{session_name}")
+	clientStateFormat = format("#{client_name}", "#{window_id}", "#{pane_id}", "#{session_name}")
+)
+
+// record is one line of format output split into its fields
+type record struct {
+	fields []string
+	err    error
+}
+
+func newRecord(line string, n int) *record {
+	r := &record{fields: strings.SplitN(line, separator, n)}
+	if len(r.fields) != n {
+		r.err = fmt.Errorf("expected %d fields in tmux output, got %d: %q", n, len(r.fields), line)
+	}
+	return r
+}
+
+func (r *record) str(i int) string {
+	if r.err != nil {
+		return ""
+	}
+	return r.fields[i]
+}
+
+func (r *record) int(i int) int {
+	if r.err != nil {
+		return 0
+	}
+	if r.fields[i] == "" {
+		return 0
+	}
+	v, err := strconv.Atoi(r.fields[i])
+	if err != nil {
+		r.err = fmt.Errorf("expected number in field %d of tmux output: %q: %w", i, r.fields[i], err)
+	}
+	return v
+}
+
+func (r *record) bool(i int) bool {
+	return r.int(i) != 0
+}
+
+func (r *record) time(i int) time.Time {
+	secs := r.int(i)
+	if secs == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(secs), 0)
+}
+
+func lines(b []byte) []string {
+	s := strings.TrimRight(string(b), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func parseSessions(b []byte) ([]Session, error) {
+	var sessions []Session
+	for _, line := range lines(b) {
+		r := newRecord(line, 6)
+		s := Session{
+			ID:       r.str(0),
+			Windows:  r.int(1),
+			Attached: r.int(2),
+			Grouped:  r.bool(3),
+			Created:  r.time(4),
+			Name:     r.str(5),
+		}
+		if r.err != nil {
+			return sessions, r.err
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, nil
+}
+
+func parseWindows(b []byte) ([]Window, error) {
+	var windows []Window
+	for _, line := range lines(b) {
+		r := newRecord(line, 7)
+		w := Window{
+			ID:      r.str(0),
+			Index:   r.int(1),
+			Active:  r.bool(2),
+			Width:   r.int(3),
+			Height:  r.int(4),
+			Session: r.str(5),
+			Name:    r.str(6),
+		}
+		if r.err != nil {
+			return windows, r.err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+func parsePanes(b []byte) ([]Pane, error) {
+	var panes []Pane
+	for _, line := range lines(b) {
+		r := newRecord(line, 10)
+		p := Pane{
+			ID:             r.str(0),
+			Index:          r.int(1),
+			Active:         r.bool(2),
+			PID:            r.int(3),
+			Window:         r.str(4),
+			TTY:            r.str(5),
+			Width:          r.int(6),
+			Height:         r.int(7),
+			CurrentCommand: r.str(8),
+			CurrentPath:    r.str(9),
+		}
+		if r.err != nil {
+			return panes, r.err
+		}
+		panes = append(panes, p)
+	}
+	return panes, nil
+}
+
+func parseClients(b []byte) ([]Client, error) {
+	var clients []Client
+	for _, line := range lines(b) {
+		r := newRecord(line, 8)
+		c := Client{
+			Name:     r.str(0),
+			TTY:      r.str(1),
+			PID:      r.int(2),
+			Width:    r.int(3),
+			Height:   r.int(4),
+			Termname: r.str(5),
+			ReadOnly: r.bool(6),
+			Session:  r.str(7),
+		}
+		if r.err != nil {
+			return clients, r.err
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+func parseClientState(b []byte) (ClientState, error) {
+	var state ClientState
+	l := lines(b)
+	if len(l) != 1 {
+		return state, fmt.Errorf("expected one line of tmux output, got %d: %q", len(l), b)
+	}
+	r := newRecord(l[0], 4)
+	state = ClientState{
+		Client:  r.str(0),
+		Window:  r.str(1),
+		Pane:    r.str(2),
+		Session: r.str(3),
+	}
+	return state, r.err
+}
@@ -0,0 +1,104 @@
+package tmux
+
+import (
+	"fmt"
+	"strings"
+)
+
+// indexes returns the indexes in use for an array option or hook as printed by show,
+// such as terminal-features[2]
+func (s Server) indexes(name string, show ...string) (map[string]bool, error) {
+	b, err := s.Run(append(show, name)...)
+	if err != nil {
+		return nil, fmt.Errorf("could not get %s: %w", name, err)
+	}
+	indexes := make(map[string]bool)
+	for _, line := range strings.Split(string(b), "\n") {
+		if index := strings.SplitN(line, " ", 2)[0]; strings.HasPrefix(index, name+"[") {
+			indexes[index] = true
+		}
+	}
+	return indexes, nil
+}
+
+// appendArray runs set with -a to add value to the array option or hook name, it returns
+// name with the index value was added at
+func (s Server) appendArray(name, value string, show, set []string) (string, error) {
+	before, err := s.indexes(name, show...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := s.Run(append(set, "-a", name, value)...); err != nil {
+		return "", fmt.Errorf("could not add to %s: %w", name, err)
+	}
+	after, err := s.indexes(name, show...)
+	if err != nil {
+		return "", err
+	}
+	for index := range after {
+		if !before[index] {
+			return index, nil
+		}
+	}
+	return "", fmt.Errorf("could not find %s after adding to it", name)
+}
+
+// AddHook runs cmd on a global hook alongside any commands already set for it,
+// it returns the hook with the index cmd was added at for RemoveHook
+func (s Server) AddHook(hook, cmd string) (string, error) {
+	return s.appendArray(hook, cmd, []string{"show-hooks", "-g"}, []string{"set-hook", "-g"})
+}
+
+// RemoveHook removes a hook added by AddHook, leaving the rest of the hook's commands
+func (s Server) RemoveHook(hook string) error {
+	if _, err := s.Run("set-hook", "-g", "-u", hook); err != nil {
+		return fmt.Errorf("could not remove hook %s: %w", hook, err)
+	}
+	return nil
+}
+
+// Hooks returns the commands of the global hooks by hook and index, such as client-resized[0]
+func (s Server) Hooks() (map[string]string, error) {
+	b, err := s.Run("show-hooks", "-g")
+	if err != nil {
+		return nil, fmt.Errorf("could not get hooks: %w", err)
+	}
+	hooks := make(map[string]string)
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) == 2 && strings.HasSuffix(fields[0], "]") {
+			hooks[fields[0]] = fields[1]
+		}
+	}
+	return hooks, nil
+}
+
+// AddServerOption adds value to a server array option such as terminal-features,
+// it returns the option with the index value was added at for RemoveServerOption
+func (s Server) AddServerOption(option, value string) (string, error) {
+	return s.appendArray(option, value, []string{"show-options", "-s"}, []string{"set-option", "-s"})
+}
+
+// RemoveServerOption removes a value added by AddServerOption
+func (s Server) RemoveServerOption(option string) error {
+	if _, err := s.Run("set-option", "-s", "-u", option); err != nil {
+		return fmt.Errorf("could not remove %s: %w", option, err)
+	}
+	return nil
+}
+
+// WaitFor blocks until channel is signalled, by Signal or a wait-for -S in a binding or hook
+func (s Server) WaitFor(channel string) error {
+	if _, err := s.Run("wait-for", channel); err != nil {
+		return fmt.Errorf("could not wait for %s: %w", channel, err)
+	}
+	return nil
+}
+
+// Signal wakes up everyone waiting for channel
+func (s Server) Signal(channel string) error {
+	if _, err := s.Run("wait-for", "-S", channel); err != nil {
+		return fmt.Errorf("could not signal %s: %w", channel, err)
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package tmux
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+var namedKeys = map[string]string{
+	"enter":  "\r",
+	"escape": "\x1b",
+	"space":  " ",
+	"tab":    "\t",
+	"bspace": "\x7f",
+}
+
+// KeyBytes returns what a terminal sends for a key named as tmux names it, such as C-b,
+// M-x, Enter or &. Only keys sent as plain characters are supported.
+func KeyBytes(name string) ([]byte, error) {
+	key := name
+	meta := false
+	if strings.HasPrefix(key, "M-") && len(key) > 2 {
+		meta = true
+		key = key[2:]
+	}
+	var b []byte
+	switch {
+	case strings.HasPrefix(key, "C-") && len(key) > 2:
+		c := strings.ToLower(key[2:])
+		switch {
+		case c == "space" || c == "@":
+			b = []byte{0}
+		case len(c) == 1 && c[0] >= 'a' && c[0] <= 'z':
+			b = []byte{c[0] - 'a' + 1}
+		case len(c) == 1 && strings.ContainsRune(`[\]^_`, rune(c[0])):
+			b = []byte{c[0] - '@'}
+		case c == "?":
+			b = []byte{0x7f}
+		default:
+			return nil, fmt.Errorf("unsupported key %q", name)
+		}
+	case namedKeys[strings.ToLower(key)] != "":
+		b = []byte(namedKeys[strings.ToLower(key)])
+	case len([]rune(key)) == 1:
+		b = []byte(key)
+	default:
+		return nil, fmt.Errorf("unsupported key %q", name)
+	}
+	if meta {
+		b = append([]byte{0x1b}, b...)
+	}
+	return b, nil
+}
+
+// Prefixes returns the bytes of the global prefix keys, prefix2 is left out when it is None
+func (s Server) Prefixes() ([][]byte, error) {
+	var prefixes [][]byte
+	for _, option := range []string{"prefix", "prefix2"} {
+		b, err := s.Run("show-options", "-gqv", option)
+		if err != nil {
+			return nil, fmt.Errorf("could not get %s: %w", option, err)
+		}
+		name := strings.TrimSpace(string(b))
+		if name == "" || name == "None" {
+			continue
+		}
+		key, err := KeyBytes(name)
+		if err != nil {
+			return nil, fmt.Errorf("could not use %s: %w", option, err)
+		}
+		prefixes = append(prefixes, key)
+	}
+	return prefixes, nil
+}
+
+// Binding is how a key was bound before it was changed, so it can be put back
+type Binding struct {
+	Table, Key string
+	// Command is the binding as list-keys prints it, empty when the key was not bound
+	Command string
+}
+
+// SaveBinding records how key is bound in table
+func (s Server) SaveBinding(table, key string) (Binding, error) {
+	b := Binding{Table: table, Key: key}
+	out, err := s.Run("list-keys", "-T", table, key)
+	var cerr *CommandError
+	if errors.As(err, &cerr) && (strings.HasPrefix(cerr.Stderr, "unknown key") || strings.HasPrefix(cerr.Stderr, "table ")) {
+		return b, nil
+	}
+	if err != nil {
+		return b, fmt.Errorf("could not get binding of %s: %w", key, err)
+	}
+	b.Command = strings.TrimSpace(string(out))
+	return b, nil
+}
+
+// RestoreBinding puts back a binding recorded by SaveBinding
+func (s Server) RestoreBinding(b Binding) error {
+	if b.Command == "" {
+		if _, err := s.Run("unbind-key", "-T", b.Table, b.Key); err != nil {
+			return fmt.Errorf("could not unbind %s: %w", b.Key, err)
+		}
+		return nil
+	}
+	// list-keys prints bindings as commands, which tmux can only read back from a file
+	f, err := ioutil.TempFile("", "pair-binding-")
+	if err != nil {
+		return fmt.Errorf("could not save binding of %s: %w", b.Key, err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(b.Command + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("could not save binding of %s: %w", b.Key, err)
+	}
+	if _, err := s.Run("source-file", f.Name()); err != nil {
+		return fmt.Errorf("could not restore binding of %s: %w", b.Key, err)
+	}
+	return nil
+}
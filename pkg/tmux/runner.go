@@ -0,0 +1,31 @@
+package tmux
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+)
+
+// Runner runs a tmux command line and returns its stdout, a failing command should return
+// a *CommandError so callers can inspect it. Replace it to test without a real tmux.
+type Runner interface {
+	Run(args []string) ([]byte, error)
+}
+
+// ExecRunner runs commands as child processes
+type ExecRunner struct{}
+
+func (ExecRunner) Run(args []string) ([]byte, error) {
+	cmd := exec.Command(args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return out, &CommandError{
+			Args:   args,
+			Stderr: strings.TrimSpace(stderr.String()),
+			Err:    err,
+		}
+	}
+	return out, nil
+}
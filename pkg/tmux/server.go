@@ -0,0 +1,326 @@
+package tmux
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// restrictedConfig only binds keys needed to work within the shared session,
+// guests cannot reach the command prompt, choose-tree or detach from it
+const restrictedConfig = `# generated by pair, removed when the session ends
+set -g prefix C-b
+set -g prefix2 None
+unbind-key -a -T prefix
+unbind-key -a -T root
+bind-key C-b send-prefix
+bind-key c new-window -c "#{pane_current_path}"
+bind-key '"' split-window -v -c "#{pane_current_path}"
+bind-key % split-window -h -c "#{pane_current_path}"
+bind-key n next-window
+bind-key p previous-window
+bind-key 0 select-window -t :=0
+bind-key 1 select-window -t :=1
+bind-key 2 select-window -t :=2
+bind-key 3 select-window -t :=3
+bind-key 4 select-window -t :=4
+bind-key 5 select-window -t :=5
+bind-key 6 select-window -t :=6
+bind-key 7 select-window -t :=7
+bind-key 8 select-window -t :=8
+bind-key 9 select-window -t :=9
+bind-key o select-pane -t :.+
+bind-key Up select-pane -U
+bind-key Down select-pane -D
+bind-key Left select-pane -L
+bind-key Right select-pane -R
+bind-key z resize-pane -Z
+bind-key [ copy-mode
+bind-key ] paste-buffer
+set -g exit-empty on
+set -g exit-unattached off
+set -g destroy-unattached off
+set -g status-left "[pair] "
+`
+
+// Server is a tmux server, the zero value is the user's default server
+type Server struct {
+	// SocketName is passed as -L
+	SocketName string
+	// SocketPath is passed as -S and takes precedence over SocketName
+	SocketPath string
+	// ConfigFile is passed as -f, it is only read when the server starts
+	ConfigFile string
+	// Dir is removed along with the server when it is killed
+	Dir string
+	// Runner runs tmux commands, nil uses ExecRunner
+	Runner Runner
+}
+
+// NewIsolatedServer prepares a private socket and restricted config for a dedicated tmux server,
+// the server itself is started by the first NewSession
+func NewIsolatedServer() (Server, error) {
+	var s Server
+	dir, err := ioutil.TempDir("", "pair-")
+	if err != nil {
+		return s, fmt.Errorf("could not create directory for tmux server: %w", err)
+	}
+	s.Dir = dir
+	s.SocketPath = filepath.Join(dir, "socket")
+	s.ConfigFile = filepath.Join(dir, "tmux.conf")
+	if err := ioutil.WriteFile(s.ConfigFile, []byte(restrictedConfig), 0600); err != nil {
+		_ = os.RemoveAll(dir)
+		return s, fmt.Errorf("could not write tmux config: %w", err)
+	}
+	return s, nil
+}
+
+// Args returns a tmux command line that targets this server
+func (s Server) Args(args ...string) []string {
+	cmd := []string{"tmux"}
+	if s.SocketPath != "" {
+		cmd = append(cmd, "-S", s.SocketPath)
+	} else if s.SocketName != "" {
+		cmd = append(cmd, "-L", s.SocketName)
+	}
+	if s.ConfigFile != "" {
+		cmd = append(cmd, "-f", s.ConfigFile)
+	}
+	return append(cmd, args...)
+}
+
+// Run runs a tmux command against this server and returns its output
+func (s Server) Run(args ...string) ([]byte, error) {
+	r := s.Runner
+	if r == nil {
+		r = ExecRunner{}
+	}
+	return r.Run(s.Args(args...))
+}
+
+// AttachCommand returns the command a guest runs to attach to session on this server only
+func (s Server) AttachCommand(session string) []string {
+	return s.Args("attach-session", "-t", session)
+}
+
+func (s Server) Version() (Version, error) {
+	b, err := s.Run("-V")
+	if err != nil {
+		return Version{}, fmt.Errorf("could not get tmux version: %w", err)
+	}
+	return ParseVersion(string(b))
+}
+
+// Display expands format in the context of target, an empty target uses the current client
+func (s Server) Display(target, format string) (string, error) {
+	args := []string{"display-message", "-p"}
+	if target != "" {
+		args = append(args, "-t", target)
+	}
+	b, err := s.Run(append(args, format)...)
+	if err != nil {
+		return "", fmt.Errorf("could not display message: %q: %w", format, err)
+	}
+	return strings.TrimSuffix(string(b), "\n"), nil
+}
+
+// CapturePane returns the visible contents of the target pane and up to history lines
+// of scrollback above it, with escape sequences for colours and attributes
+func (s Server) CapturePane(target string, history int) ([]byte, error) {
+	args := []string{"capture-pane", "-p", "-e", "-S", fmt.Sprint(-history)}
+	if target != "" {
+		args = append(args, "-t", target)
+	}
+	b, err := s.Run(args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not capture pane: %s: %w", target, err)
+	}
+	return b, nil
+}
+
+func (s Server) Sessions() ([]Session, error) {
+	b, err := s.Run("list-sessions", "-F", sessionFormat)
+	if err != nil {
+		return nil, fmt.Errorf("could not list sessions: %w", err)
+	}
+	return parseSessions(b)
+}
+
+// Windows lists the windows in session, or all windows when session is empty
+func (s Server) Windows(session string) ([]Window, error) {
+	args := []string{"list-windows", "-F", windowFormat}
+	if session == "" {
+		args = append(args, "-a")
+	} else {
+		args = append(args, "-t", session)
+	}
+	b, err := s.Run(args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not list windows in session: %s: %w", session, err)
+	}
+	return parseWindows(b)
+}
+
+// Panes lists the panes in all windows of session
+func (s Server) Panes(session string) ([]Pane, error) {
+	b, err := s.Run("list-panes", "-s", "-t", session, "-F", paneFormat)
+	if err != nil {
+		return nil, fmt.Errorf("could not list panes in session: %s: %w", session, err)
+	}
+	return parsePanes(b)
+}
+
+// Clients lists the clients attached to session, or all clients when session is empty
+func (s Server) Clients(session string) ([]Client, error) {
+	args := []string{"list-clients", "-F", clientFormat}
+	if session != "" {
+		args = append(args, "-t", session)
+	}
+	b, err := s.Run(args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not list clients in session: %s: %w", session, err)
+	}
+	return parseClients(b)
+}
+
+// ClientState returns where the current client is, Client is empty when run outside of one
+func (s Server) ClientState() (ClientState, error) {
+	b, err := s.Run("display-message", "-p", clientStateFormat)
+	if err != nil {
+		return ClientState{}, fmt.Errorf("could not get client state: %w", err)
+	}
+	return parseClientState(b)
+}
+
+// RestoreClientState switches the client back to the recorded session, window and pane,
+// the window and pane may have since been closed so are only selected if they still exist
+func (s Server) RestoreClientState(state ClientState) error {
+	if err := s.SwitchClient(state.Client, state.Session); err != nil {
+		return err
+	}
+	if state.Window != "" {
+		_, _ = s.Run("select-window", "-t", state.Window)
+	}
+	if state.Pane != "" {
+		_, _ = s.Run("select-pane", "-t", state.Pane)
+	}
+	return nil
+}
+
+func (s Server) SwitchClient(client, session string) error {
+	_, err := s.Run("switch-client", "-c", client, "-t", session)
+	if err != nil {
+		return fmt.Errorf("could not switch client: %s to session: %s: %w", client, session, err)
+	}
+	return nil
+}
+
+func (s Server) HasSession(session string) (bool, error) {
+	_, err := s.Run("has-session", "-t", "="+session)
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrNoServer) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not check for session: %s: %w", session, err)
+	}
+	return true, nil
+}
+
+// NewSession creates a detached session starting in dir, an empty dir uses the working directory
+func (s Server) NewSession(session, dir string) error {
+	args := []string{"new-session", "-d", "-s", session}
+	if dir != "" {
+		args = append(args, "-c", dir)
+	}
+	if _, err := s.Run(args...); err != nil {
+		return fmt.Errorf("failed to create new session: %s: %w", session, err)
+	}
+	return nil
+}
+
+// EnsureSession creates session if it does not exist, reporting whether it was created
+func (s Server) EnsureSession(session string) (bool, error) {
+	exists, err := s.HasSession(session)
+	if err != nil || exists {
+		return false, err
+	}
+	if err := s.NewSession(session, ""); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NewGroupedSession creates session sharing the windows of target, each session in a group
+// has its own current window so clients attached to them can look at different windows.
+// It returns the id of the new session.
+func (s Server) NewGroupedSession(target, session string) (string, error) {
+	b, err := s.Run("new-session", "-d", "-t", target, "-s", session, "-P", "-F", "#{session_id}")
+	if err != nil {
+		return "", fmt.Errorf("failed to create session: %s grouped with: %s: %w", session, target, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func (s Server) KillSession(session string) error {
+	if _, err := s.Run("kill-session", "-t", "="+session); err != nil {
+		return fmt.Errorf("could not kill session: %s: %w", session, err)
+	}
+	return nil
+}
+
+// LinkWindows creates session containing only the given windows of source,
+// the windows are linked rather than moved so source is left untouched
+func (s Server) LinkWindows(source, session string, windows []string) error {
+	if len(windows) == 0 {
+		return fmt.Errorf("no windows provided to link into session: %s", session)
+	}
+	exists, err := s.HasSession(session)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("could not link windows: %s: %w", session, ErrDuplicateSession)
+	}
+	b, err := s.Run("new-session", "-d", "-s", session, "-P", "-F", "#{window_id}")
+	if err != nil {
+		return fmt.Errorf("failed to create new session: %s: %w", session, err)
+	}
+	placeholder := strings.TrimSpace(string(b))
+	for _, w := range windows {
+		if _, err := s.Run("link-window", "-d", "-s", source+":"+w, "-t", session+":"); err != nil {
+			_ = s.KillSession(session)
+			return fmt.Errorf("could not link window: %s:%s to session: %s: %w", source, w, session, err)
+		}
+	}
+	if _, err := s.Run("kill-window", "-t", placeholder); err != nil {
+		return fmt.Errorf("could not remove placeholder window: %s: %w", placeholder, err)
+	}
+	return nil
+}
+
+// Kill stops the server and removes its directory
+func (s Server) Kill() error {
+	_, err := s.Run("kill-server")
+	if err != nil && !errors.Is(err, ErrNoServer) {
+		return fmt.Errorf("could not kill tmux server: %w", err)
+	}
+	if s.Dir != "" {
+		if err := os.RemoveAll(s.Dir); err != nil {
+			return fmt.Errorf("could not remove tmux server directory: %w", err)
+		}
+	}
+	return nil
+}
+
+// OpenInWindow opens a new window in session on host that is attached to target on this server,
+// it is how the host sees an isolated session from within their own tmux
+func (s Server) OpenInWindow(host Server, session, target string) error {
+	args := append([]string{"new-window", "-t", session + ":", "-n", "pair", "--", "env", "-u", "TMUX"}, s.AttachCommand(target)...)
+	if _, err := host.Run(args...); err != nil {
+		return fmt.Errorf("could not open window attached to isolated session: %s: %w", target, err)
+	}
+	return nil
+}
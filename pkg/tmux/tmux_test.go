@@ -0,0 +1,406 @@
+package tmux
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type fakeRunner struct {
+	calls  [][]string
+	output map[string]string
+	stderr map[string]string
+}
+
+func (f *fakeRunner) Run(args []string) ([]byte, error) {
+	f.calls = append(f.calls, args)
+	for cmd, stderr := range f.stderr {
+		if strings.Contains(strings.Join(args, " "), cmd) {
+			return nil, &CommandError{Args: args, Stderr: stderr, Err: errors.New("exit status 1")}
+		}
+	}
+	for cmd, out := range f.output {
+		if strings.Contains(strings.Join(args, " "), cmd) {
+			return []byte(out), nil
+		}
+	}
+	return nil, nil
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := map[string]Version{
+		"tmux 3.3a\n":      {Major: 3, Minor: 3, Suffix: "a"},
+		"tmux 2.9":         {Major: 2, Minor: 9},
+		"tmux next-3.4":    {Major: 3, Minor: 4, Master: true},
+		"tmux master":      {Master: true},
+		"tmux 3.1-rc2":     {Major: 3, Minor: 1, Suffix: "-rc2"},
+		"tmux openbsd-7.0": {},
+	}
+	for in, expected := range tests {
+		v, err := ParseVersion(in)
+		if expected == (Version{}) {
+			if err == nil {
+				t.Errorf("expected error parsing %q, got %+v", in, v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("unexpected error parsing %q: %s", in, err)
+		}
+		if !cmp.Equal(v, expected) {
+			t.Errorf("parsing %q got %+v, expected %+v", in, v, expected)
+		}
+	}
+	v := Version{Major: 3, Minor: 0}
+	if !v.AtLeast(2, 9) || !v.AtLeast(3, 0) || v.AtLeast(3, 1) {
+		t.Errorf("unexpected comparison for %s", v)
+	}
+}
+
+func TestParseWindowsWithSeparatorInName(t *testing.T) {
+	out := "@1\x1f0\x1f1\x1f80\x1f24\x1fmain\x1fvim\n" +
+		"@2\x1f1\x1f0\x1f80\x1f24\x1fmain\x1fodd\x1fname\n"
+	expected := []Window{
+		{ID: "@1", Index: 0, Active: true, Width: 80, Height: 24, Session: "main", Name: "vim"},
+		{ID: "@2", Index: 1, Active: false, Width: 80, Height: 24, Session: "main", Name: "odd\x1fname"},
+	}
+	s := Server{Runner: &fakeRunner{output: map[string]string{"list-windows": out}}}
+	windows, err := s.Windows("main")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !cmp.Equal(windows, expected) {
+		t.Errorf("got %+v, expected %+v", windows, expected)
+	}
+}
+
+func TestParseSessions(t *testing.T) {
+	out := "$0\x1f2\x1f1\x1f0\x1f1600000000\x1fmain\n"
+	expected := []Session{{ID: "$0", Windows: 2, Attached: 1, Created: time.Unix(1600000000, 0), Name: "main"}}
+	s := Server{Runner: &fakeRunner{output: map[string]string{"list-sessions": out}}}
+	sessions, err := s.Sessions()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !cmp.Equal(sessions, expected) {
+		t.Errorf("got %+v, expected %+v", sessions, expected)
+	}
+	s = Server{Runner: &fakeRunner{output: map[string]string{"list-sessions": "$0\x1fnope\x1f1\x1f0\x1f0\x1fmain\n"}}}
+	if _, err := s.Sessions(); err == nil {
+		t.Errorf("expected error for malformed output")
+	}
+}
+
+func TestCommandErrors(t *testing.T) {
+	s := Server{SocketName: "test", Runner: &fakeRunner{stderr: map[string]string{
+		"has-session":  "can't find session: nope",
+		"list-clients": "no server running on /tmp/tmux-1000/test",
+		"kill-session": "duplicate session: nope",
+	}}}
+	exists, err := s.HasSession("nope")
+	if err != nil || exists {
+		t.Errorf("missing session should not exist without error: %v %v", exists, err)
+	}
+	_, err = s.Clients("")
+	if !errors.Is(err, ErrNoServer) {
+		t.Errorf("expected no server error: %v", err)
+	}
+	var cerr *CommandError
+	if !errors.As(err, &cerr) || cerr.Args[1] != "-L" {
+		t.Errorf("expected command error with socket args: %#v", err)
+	}
+	if err := s.KillSession("nope"); errors.Is(err, ErrNotFound) {
+		t.Errorf("unexpected not found error: %v", err)
+	}
+}
+
+func TestRealServer(t *testing.T) {
+	if !HasBinary() {
+		t.Skip("tmux not installed")
+	}
+	s := Server{SocketPath: filepath.Join(t.TempDir(), "socket")}
+	defer s.Kill()
+	if _, err := s.Version(); err != nil {
+		t.Fatalf("unexpected error getting version: %s", err)
+	}
+	if _, err := s.Sessions(); !errors.Is(err, ErrNoServer) {
+		t.Errorf("expected no server error: %v", err)
+	}
+	created, err := s.EnsureSession("pair")
+	if err != nil || !created {
+		t.Fatalf("expected session to be created: %v %s", created, err)
+	}
+	created, err = s.EnsureSession("pair")
+	if err != nil || created {
+		t.Fatalf("expected existing session to be reused: %v %s", created, err)
+	}
+	windows, err := s.Windows("pair")
+	if err != nil || len(windows) != 1 || windows[0].Session != "pair" {
+		t.Errorf("unexpected windows: %+v %v", windows, err)
+	}
+	panes, err := s.Panes("pair")
+	if err != nil || len(panes) != 1 || panes[0].Window != windows[0].ID {
+		t.Errorf("unexpected panes: %+v %v", panes, err)
+	}
+	clients, err := s.Clients("pair")
+	if err != nil || len(clients) != 0 {
+		t.Errorf("unexpected clients: %+v %v", clients, err)
+	}
+	if err := s.LinkWindows("pair", "linked", []string{windows[0].ID}); err != nil {
+		t.Fatalf("unexpected error linking windows: %s", err)
+	}
+	linked, err := s.Windows("linked")
+	if err != nil || len(linked) != 1 || linked[0].ID != windows[0].ID {
+		t.Errorf("unexpected linked windows: %+v %v", linked, err)
+	}
+	screen, err := s.CapturePane(windows[0].ID, 100)
+	if err != nil || len(screen) == 0 {
+		t.Errorf("unexpected capture: %q %v", screen, err)
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	tests := map[string]Event{
+		"%window-renamed @1 my window":            {Name: EventWindowRenamed, Args: []string{"@1", "my window"}},
+		"%session-window-changed $0 @2":           {Name: EventSessionWindowChanged, Args: []string{"$0", "@2"}},
+		"%client-session-changed /dev/pts/1 $0 x": {Name: EventClientSessionChanged, Args: []string{"/dev/pts/1", "$0", "x"}},
+		"%sessions-changed":                       {Name: EventSessionsChanged},
+		"%exit":                                   {Name: EventExit},
+		"%paste-buffer-changed buffer0":           {Name: "paste-buffer-changed", Args: []string{"buffer0"}},
+	}
+	for line, expected := range tests {
+		ev, ok := parseEvent(line)
+		if !ok || !cmp.Equal(ev, expected) {
+			t.Errorf("parsing %q got %+v, expected %+v", line, ev, expected)
+		}
+	}
+	if _, ok := parseEvent("%output %1 hello"); ok {
+		t.Errorf("pane output should not be delivered as an event")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := map[string]string{
+		"select-window": "select-window",
+		"$0":            "'$0'",
+		"two words":     "'two words'",
+		"it's":          `"it's"`,
+		`a"$b`:          `'a"$b'`,
+		"":              "''",
+	}
+	for in, expected := range tests {
+		if q := quote(in); q != expected {
+			t.Errorf("quoting %q got %s, expected %s", in, q, expected)
+		}
+	}
+}
+
+func TestRealControl(t *testing.T) {
+	if !HasBinary() {
+		t.Skip("tmux not installed")
+	}
+	s := Server{SocketPath: filepath.Join(t.TempDir(), "socket")}
+	defer s.Kill()
+	if err := s.NewSession("pair", ""); err != nil {
+		t.Fatalf("unexpected error creating session: %s", err)
+	}
+	c, err := s.Control("pair")
+	if err != nil {
+		t.Fatalf("unexpected error starting control client: %s", err)
+	}
+	defer c.Close()
+	lines, err := c.Command("display-message", "-p", "it's #{session_name}")
+	if err != nil || !cmp.Equal(lines, []string{"it's pair"}) {
+		t.Errorf("unexpected reply: %q %v", lines, err)
+	}
+	if _, err := c.Command("select-window", "-t", "nope"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected not found error: %v", err)
+	}
+	if _, err := c.Command("rename-window", "-t", "pair:0", "renamed window"); err != nil {
+		t.Errorf("unexpected error renaming window: %s", err)
+	}
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-c.Events():
+			if ev.Name == EventWindowRenamed {
+				if ev.Args[1] != "renamed window" {
+					t.Errorf("unexpected event: %+v", ev)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for rename event")
+		}
+	}
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestControlDropsUnwantedReplies(t *testing.T) {
+	r, w := io.Pipe()
+	c := NewControl(r, nopWriteCloser{ioutil.Discard})
+	// a reply to a command nobody is waiting for must not stop events being delivered
+	go func() {
+		_, _ = io.WriteString(w, "%begin 1 1 1\nstray\n%end 1 1 1\n%begin 2 2 1\n%end 2 2 1\n%sessions-changed\n")
+		_ = w.Close()
+	}()
+	select {
+	case ev := <-c.Events():
+		if ev.Name != EventSessionsChanged {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("reader blocked on a reply nobody wanted")
+	}
+	<-c.Done()
+}
+
+func TestKeyBytes(t *testing.T) {
+	tests := map[string]string{
+		"C-b":     "\x02",
+		"C-A":     "\x01",
+		"C-Space": "\x00",
+		"C-[":     "\x1b",
+		"M-x":     "\x1bx",
+		"M-C-a":   "\x1b\x01",
+		"Enter":   "\r",
+		"escape":  "\x1b",
+		"&":       "&",
+		":":       ":",
+		"é":       "é",
+	}
+	for in, expected := range tests {
+		b, err := KeyBytes(in)
+		if err != nil {
+			t.Errorf("unexpected error for %q: %s", in, err)
+			continue
+		}
+		if string(b) != expected {
+			t.Errorf("%q got %q, expected %q", in, b, expected)
+		}
+	}
+	for _, in := range []string{"", "C-", "C-1", "F1", "Up"} {
+		if b, err := KeyBytes(in); err == nil {
+			t.Errorf("expected error for %q, got %q", in, b)
+		}
+	}
+}
+
+func TestRealBindings(t *testing.T) {
+	if !HasBinary() {
+		t.Skip("tmux not installed")
+	}
+	s := Server{SocketPath: filepath.Join(t.TempDir(), "socket"), ConfigFile: "/dev/null"}
+	defer s.Kill()
+	if err := s.NewSession("pair", ""); err != nil {
+		t.Fatalf("unexpected error creating session: %s", err)
+	}
+	if _, err := s.Run("bind-key", "-r", "F", "display-message", "it's mine"); err != nil {
+		t.Fatal(err)
+	}
+	before, _ := s.Run("list-keys", "-T", "prefix", "F")
+	bound, err := s.SaveBinding("prefix", "F")
+	if err != nil {
+		t.Fatalf("unexpected error saving binding: %s", err)
+	}
+	unbound, err := s.SaveBinding("prefix", "M-F")
+	if err != nil || unbound.Command != "" {
+		t.Fatalf("expected no binding: %+v %v", unbound, err)
+	}
+	for _, key := range []string{"F", "M-F"} {
+		if _, err := s.Run("bind-key", key, "display-message", "pair"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.RestoreBinding(bound); err != nil {
+		t.Fatalf("unexpected error restoring binding: %s", err)
+	}
+	if err := s.RestoreBinding(unbound); err != nil {
+		t.Fatalf("unexpected error restoring binding: %s", err)
+	}
+	if after, _ := s.Run("list-keys", "-T", "prefix", "F"); string(after) != string(before) {
+		t.Errorf("binding not restored: %q, expected %q", after, before)
+	}
+	if _, err := s.Run("list-keys", "-T", "prefix", "M-F"); err == nil {
+		t.Errorf("expected M-F to be unbound again")
+	}
+}
+
+func TestRealHooks(t *testing.T) {
+	if !HasBinary() {
+		t.Skip("tmux not installed")
+	}
+	s := Server{SocketPath: filepath.Join(t.TempDir(), "socket"), ConfigFile: "/dev/null"}
+	defer s.Kill()
+	if err := s.NewSession("pair", ""); err != nil {
+		t.Fatalf("unexpected error creating session: %s", err)
+	}
+	if _, err := s.Run("set-hook", "-g", "session-renamed", "display-message mine"); err != nil {
+		t.Fatal(err)
+	}
+	hook, err := s.AddHook("session-renamed", "wait-for -S renamed")
+	if err != nil {
+		t.Fatalf("unexpected error adding hook: %s", err)
+	}
+	hooks, err := s.Hooks()
+	if err != nil {
+		t.Fatalf("unexpected error listing hooks: %s", err)
+	}
+	if hooks[hook] != "wait-for -S renamed" || hooks["session-renamed[0]"] != "display-message mine" {
+		t.Errorf("unexpected hooks %q", hooks)
+	}
+	waited := make(chan error)
+	go func() { waited <- s.WaitFor("renamed") }()
+	if _, err := s.Run("rename-session", "-t", "pair", "renamed"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-waited:
+		if err != nil {
+			t.Fatalf("unexpected error waiting: %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("hook did not signal")
+	}
+	if err := s.RemoveHook(hook); err != nil {
+		t.Fatalf("unexpected error removing hook: %s", err)
+	}
+	b, _ := s.Run("show-hooks", "-g", "session-renamed")
+	if got := strings.TrimSpace(string(b)); got != "session-renamed[0] display-message mine" {
+		t.Errorf("expected only the existing hook to be left, got %q", got)
+	}
+}
+
+func TestRealServerOptions(t *testing.T) {
+	if !HasBinary() {
+		t.Skip("tmux not installed")
+	}
+	s := Server{SocketPath: filepath.Join(t.TempDir(), "socket"), ConfigFile: "/dev/null"}
+	defer s.Kill()
+	if err := s.NewSession("pair", ""); err != nil {
+		t.Fatalf("unexpected error creating session: %s", err)
+	}
+	before, _ := s.Run("show-options", "-s", "terminal-features")
+	option, err := s.AddServerOption("terminal-features", "pair:RGB")
+	if err != nil {
+		t.Fatalf("unexpected error adding option: %s", err)
+	}
+	if b, _ := s.Run("show-options", "-s", option); !strings.Contains(string(b), "pair:RGB") {
+		t.Errorf("expected %s to be pair:RGB, got %q", option, b)
+	}
+	if err := s.RemoveServerOption(option); err != nil {
+		t.Fatalf("unexpected error removing option: %s", err)
+	}
+	if after, _ := s.Run("show-options", "-s", "terminal-features"); string(after) != string(before) {
+		t.Errorf("option not restored: %q, expected %q", after, before)
+	}
+}
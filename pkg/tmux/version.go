@@ -0,0 +1,70 @@
+package tmux
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version of a tmux binary, development builds report as Master
+type Version struct {
+	Major  int
+	Minor  int
+	Suffix string
+	Master bool
+}
+
+// ParseVersion parses the output of tmux -V, eg: "tmux 3.3a", "tmux next-3.4" or "tmux master"
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	s = strings.TrimSpace(s)
+	fields := strings.Fields(s)
+	if len(fields) != 2 || fields[0] != "tmux" {
+		return v, fmt.Errorf("unexpected tmux version: %q", s)
+	}
+	num := fields[1]
+	if num == "master" {
+		v.Master = true
+		return v, nil
+	}
+	if strings.HasPrefix(num, "next-") {
+		v.Master = true
+		num = strings.TrimPrefix(num, "next-")
+	}
+	parts := strings.SplitN(num, ".", 2)
+	if len(parts) != 2 {
+		return v, fmt.Errorf("unexpected tmux version: %q", s)
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return v, fmt.Errorf("unexpected tmux major version: %q: %w", s, err)
+	}
+	end := 0
+	for end < len(parts[1]) && parts[1][end] >= '0' && parts[1][end] <= '9' {
+		end++
+	}
+	minor, err := strconv.Atoi(parts[1][:end])
+	if err != nil {
+		return v, fmt.Errorf("unexpected tmux minor version: %q: %w", s, err)
+	}
+	v.Major, v.Minor, v.Suffix = major, minor, parts[1][end:]
+	return v, nil
+}
+
+// AtLeast reports whether v is major.minor or newer, development builds are always new enough
+func (v Version) AtLeast(major, minor int) bool {
+	if v.Master {
+		return true
+	}
+	if v.Major != major {
+		return v.Major > major
+	}
+	return v.Minor >= minor
+}
+
+func (v Version) String() string {
+	if v.Master && v.Major == 0 {
+		return "master"
+	}
+	return fmt.Sprintf("%d.%d%s", v.Major, v.Minor, v.Suffix)
+}